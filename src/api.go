@@ -1,7 +1,11 @@
 package main
 
 import (
-	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
@@ -10,10 +14,14 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt"
 	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
 )
 
 type ServerConfig struct {
@@ -24,28 +32,64 @@ type ServerConfig struct {
 
 type APIServer struct {
 	ServerConfig
-	Runner      *ContainerRunner
-	bucket      *Bucket
-	InfoLogger  *log.Logger
-	ErrorLogger *log.Logger
-	jwtSecret   []byte
+	Runner       *ContainerRunner
+	Jobs         *JobManager
+	Console      *ConsoleHistory
+	Scheduler    *Scheduler
+	Verifier     *Verifier
+	Snapshots    *SnapshotStore
+	Syncer       *SyncEngine
+	Transfers    *TransferManager
+	Retention    RetentionPolicy
+	BackupFormat ArchiveFormat // format of backups that don't choose one
+	store        BackupStore
+	InfoLogger   *log.Logger
+	ErrorLogger  *log.Logger
+	jwtSecret    []byte
+
+	// lifecycle serializes everything that starts or stops the server or reads or replaces
+	// mcdata as a whole: backups, snapshots, restores and start/stop jobs
+	lifecycle sync.Mutex
 }
 
-func NewAPIServer(lp string, templatePath string, logsPath string, r *ContainerRunner, b *Bucket, secret string) *APIServer {
-	return &APIServer{
+func NewAPIServer(lp string, templatePath string, logsPath string, r *ContainerRunner, store BackupStore, secret string) *APIServer {
+	s := &APIServer{
 		ServerConfig: ServerConfig{
 			ListenPort:   lp,
 			TemplatePath: templatePath,
 			LogsPath:     logsPath,
 		},
-		Runner:    r,
-		bucket:    b,
-		jwtSecret: []byte(secret),
+		Runner:       r,
+		Jobs:         NewJobManager(),
+		Console:      NewConsoleHistory(),
+		BackupFormat: FormatZip,
+		store:        store,
+		jwtSecret:    []byte(secret),
 	}
+	s.Scheduler = NewScheduler(filepath.Join(stateDir, "schedules.json"), s.runScheduledBackup)
+	s.Transfers = NewTransferManager(store)
+	s.Verifier = NewVerifier(filepath.Join(stateDir, "verify.json"), store, s.Transfers)
+	s.Syncer = NewSyncEngine(filepath.Join(stateDir, "sync.json"), filepath.Join(stateDir, "sync-history.json"), store, s.Transfers)
+
+	snapshots, err := NewSnapshotStore(snapshotsDir, chunksDir)
+	if err != nil {
+		log.Fatalln("failed to create snapshot store", err)
+	}
+	s.Snapshots = snapshots
+
+	return s
 }
 
 func (s *APIServer) Run() {
 
+	if err := s.Scheduler.Start(); err != nil {
+		log.Fatalln(err)
+	}
+	if err := s.Verifier.Start(); err != nil {
+		log.Fatalln(err)
+	}
+	go describeBackups()
+
 	r := mux.NewRouter()
 
 	r.HandleFunc("/", s.LoginPage).Methods("GET")
@@ -57,13 +101,60 @@ func (s *APIServer) Run() {
 
 	r.Handle("/home", s.JwtAuth(http.HandlerFunc(s.Home))).Methods("GET")
 	r.Handle("/logs", s.JwtAuth(http.HandlerFunc(s.Logs))).Methods("GET")
+	r.Handle("/api/logs", s.JwtAuth(http.HandlerFunc(s.LogsAPI))).Methods("GET")
+	r.Handle("/api/logs/stream", s.JwtAuth(http.HandlerFunc(s.StreamLogs))).Methods("GET")
+	r.Handle("/logs/archive", s.JwtAuth(http.HandlerFunc(s.LogArchivePage))).Methods("GET")
+	r.Handle("/logs/archive/{name}", s.JwtAuth(http.HandlerFunc(s.LogArchiveViewPage))).Methods("GET")
+	r.Handle("/api/logs/archive", s.JwtAuth(http.HandlerFunc(s.ListLogArchives))).Methods("GET")
+	r.Handle("/api/logs/archive/{name}", s.JwtAuth(http.HandlerFunc(s.GetLogArchive))).Methods("GET")
+	r.Handle("/api/logs/search", s.JwtAuth(http.HandlerFunc(s.SearchLogs))).Methods("GET")
 
 	r.Handle("/backups", s.JwtAuth(http.HandlerFunc(s.BackupPage))).Methods("GET")
 	r.Handle("/backup", s.JwtAuth(http.HandlerFunc(s.Backup))).Methods("POST")
 	r.Handle("/backup/delete", s.JwtAuth(http.HandlerFunc(s.DeleteBackup))).Methods("DELETE")
+	r.Handle("/api/backups/verify", s.JwtAuth(http.HandlerFunc(s.ListVerifications))).Methods("GET")
+	r.Handle("/api/backups/{name}/verify", s.JwtAuth(http.HandlerFunc(s.VerifyBackup))).Methods("POST")
+	r.Handle("/api/backups/{name}/contents", s.JwtAuth(http.HandlerFunc(s.BackupContents))).Methods("GET")
+	r.Handle("/api/backups/{name}/download", s.JwtAuth(http.HandlerFunc(s.DownloadBackup))).Methods("GET", "HEAD")
+	r.Handle("/api/backups/{name}", s.JwtAuth(http.HandlerFunc(s.GetBackupInfo))).Methods("GET")
 	r.Handle("/backup/load", s.JwtAuth(http.HandlerFunc(s.LoadBackup))).Methods("POST")
 
+	r.Handle("/api/snapshots", s.JwtAuth(http.HandlerFunc(s.ListSnapshots))).Methods("GET")
+	r.Handle("/api/snapshots", s.JwtAuth(http.HandlerFunc(s.TakeSnapshot))).Methods("POST")
+	r.Handle("/api/snapshots/gc", s.JwtAuth(http.HandlerFunc(s.SnapshotGC))).Methods("POST")
+	r.Handle("/api/snapshots/{name}/contents", s.JwtAuth(http.HandlerFunc(s.SnapshotContents))).Methods("GET")
+	r.Handle("/api/snapshots/{name}/restore", s.JwtAuth(http.HandlerFunc(s.RestoreSnapshot))).Methods("POST")
+	r.Handle("/api/snapshots/{name}", s.JwtAuth(http.HandlerFunc(s.GetSnapshot))).Methods("GET")
+	r.Handle("/api/snapshots/{name}", s.JwtAuth(http.HandlerFunc(s.DeleteSnapshot))).Methods("DELETE")
+
+	r.Handle("/api/cloud/backups", s.JwtAuth(http.HandlerFunc(s.ListCloudBackups))).Methods("GET")
+	r.Handle("/api/cloud/backups/{name}/fetch", s.JwtAuth(http.HandlerFunc(s.FetchCloudBackup))).Methods("POST")
+	r.Handle("/api/cloud/backups/{name}/restore", s.JwtAuth(http.HandlerFunc(s.RestoreCloudBackup))).Methods("POST")
+	r.Handle("/api/cloud/backups/{name}/storage-class", s.JwtAuth(http.HandlerFunc(s.SetCloudStorageClass))).Methods("POST")
+	r.Handle("/api/cloud/backups/{name}", s.JwtAuth(http.HandlerFunc(s.DeleteCloudBackup))).Methods("DELETE")
+
 	r.Handle("/sync", s.JwtAuth(http.HandlerFunc(s.Sync))).Methods("POST")
+	r.Handle("/api/sync/preview", s.JwtAuth(http.HandlerFunc(s.SyncPreview))).Methods("GET")
+	r.Handle("/api/sync/state", s.JwtAuth(http.HandlerFunc(s.SyncState))).Methods("GET")
+	r.Handle("/api/sync/history", s.JwtAuth(http.HandlerFunc(s.SyncHistory))).Methods("GET")
+
+	r.Handle("/api/retention/preview", s.JwtAuth(http.HandlerFunc(s.RetentionPreview))).Methods("GET")
+
+	r.Handle("/api/schedules", s.JwtAuth(http.HandlerFunc(s.ListSchedules))).Methods("GET")
+	r.Handle("/api/schedules", s.JwtAuth(http.HandlerFunc(s.AddSchedule))).Methods("POST")
+	r.Handle("/api/schedules/{id}/pause", s.JwtAuth(http.HandlerFunc(s.PauseSchedule))).Methods("POST")
+	r.Handle("/api/schedules/{id}/resume", s.JwtAuth(http.HandlerFunc(s.ResumeSchedule))).Methods("POST")
+	r.Handle("/api/schedules/{id}", s.JwtAuth(http.HandlerFunc(s.DeleteSchedule))).Methods("DELETE")
+
+	r.Handle("/api/status", s.JwtAuth(http.HandlerFunc(s.ContainerStatus))).Methods("GET")
+	r.Handle("/api/jobs", s.JwtAuth(http.HandlerFunc(s.ListJobs))).Methods("GET")
+	r.Handle("/api/jobs/{id}", s.JwtAuth(http.HandlerFunc(s.GetJob))).Methods("GET")
+	r.Handle("/api/jobs/{id}/cancel", s.JwtAuth(http.HandlerFunc(s.CancelJob))).Methods("POST")
+
+	r.Handle("/console", s.JwtAuth(http.HandlerFunc(s.ConsolePage))).Methods("GET")
+	r.Handle("/api/console", s.JwtAuth(http.HandlerFunc(s.ConsoleHistory))).Methods("GET")
+	r.Handle("/api/console", s.JwtAuth(http.HandlerFunc(s.ConsoleCommand))).Methods("POST")
+	r.Handle("/api/console/ws", s.JwtAuth(http.HandlerFunc(s.ConsoleWebSocket))).Methods("GET")
 
 	fmt.Printf("Server listening on port %v\n", s.ListenPort)
 	if err := http.ListenAndServe(s.ListenPort, r); err != nil {
@@ -71,22 +162,105 @@ func (s *APIServer) Run() {
 	}
 }
 
-func (s *APIServer) LoadBackup(w http.ResponseWriter, r *http.Request) {
+// BackupContents lists the files and directories of a local backup below ?prefix= (top level by default)
+func (s *APIServer) BackupContents(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	archivePath, err := backupPath(name)
+	if err != nil {
+		WriteJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	if _, err := archiveFormatOf(name); err != nil {
+		WriteJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	if _, err := os.Stat(archivePath); err != nil {
+		WriteJSONError(w, http.StatusNotFound, fmt.Errorf("backup %s not found", name))
+		return
+	}
+
+	entries, err := ListArchiveContents(archivePath, r.URL.Query().Get("prefix"))
+	if err != nil {
+		log.Printf("Error listing contents of %s: %v", name, err)
+		WriteJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, entries)
+}
+
+// DownloadBackup streams a local backup, or proxies it from the backup store if it only exists there.
+// Range requests are supported so interrupted downloads can be resumed.
+func (s *APIServer) DownloadBackup(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	path, err := backupPath(name)
+	if err != nil {
+		WriteJSONError(w, http.StatusBadRequest, err)
+		return
+	}
 
-	//		http.Error(w, err.Error(), http.StatusInternalServerError)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
 
-	s.Runner.StopContainer()
+	file, err := os.Open(path)
+	if err == nil {
+		defer file.Close()
+		info, err := file.Stat()
+		if err != nil {
+			WriteJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if manifest, err := readManifest(name); err == nil && manifest.SHA256 != "" {
+			setChecksumHeaders(w, manifest.SHA256)
+			w.Header().Set("ETag", `"`+manifest.SHA256+`"`)
+		}
+		log.Printf("downloading backup %s (range %q)\n", name, r.Header.Get("Range"))
+		http.ServeContent(w, r, name, info.ModTime(), file)
+		return
+	}
+	if !os.IsNotExist(err) {
+		WriteJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if s.store == nil {
+		WriteJSONError(w, http.StatusNotFound, fmt.Errorf("backup %s not found", name))
+		return
+	}
+	info, err := s.store.Stat(r.Context(), name)
+	if errors.Is(err, ErrObjectNotExist) {
+		WriteJSONError(w, http.StatusNotFound, fmt.Errorf("backup %s not found", name))
+		return
+	}
+	if err != nil {
+		log.Printf("Error looking up %s in %s: %v", name, s.store.Name(), err)
+		WriteJSONError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	if info.MD5 != "" {
+		w.Header().Set("X-Checksum-MD5", info.MD5)
+	}
+	w.Header().Set("ETag", `"`+info.Generation+`"`)
+
+	reader := newObjectReadSeeker(r.Context(), s.store, info)
+	defer reader.Close()
+
+	log.Printf("downloading backup %s from %s (range %q)\n", name, s.store.Name(), r.Header.Get("Range"))
+	http.ServeContent(w, r, name, info.Updated, reader)
+}
+
+// setChecksumHeaders describes the whole backup, also for range responses
+func setChecksumHeaders(w http.ResponseWriter, sha256Hex string) {
+	w.Header().Set("X-Checksum-SHA256", sha256Hex)
+	if sum, err := hex.DecodeString(sha256Hex); err == nil {
+		w.Header().Set("Digest", "sha-256="+base64.StdEncoding.EncodeToString(sum))
+	}
+}
+
+// LoadBackup saves an uploaded backup (?file=true) or restores a backup from disk as a job
+func (s *APIServer) LoadBackup(w http.ResponseWriter, r *http.Request) {
 	backupFile := r.FormValue("backup")
 	fileFlag := r.URL.Query().Get("file")
 
-	// shutdown server
-	// Format the time to match the desired format
-	//backup current state
-	//remove current server files
-	//unzip backup to mcdata/
-	//start the server
-	// log.Println("Headers:", r.Header)
-
 	if fileFlag == "true" {
 
 		r.Header.Set("Content-Type", "multipart/form-data")
@@ -102,158 +276,107 @@ func (s *APIServer) LoadBackup(w http.ResponseWriter, r *http.Request) {
 		}
 		defer file.Close()
 
-		//todo: input validation for file name
 		fileName := fileHeader.Filename
 		if err := s.LoadBackupChooseFile(file, fileName); err != nil {
-			log.Fatalln(err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
-	} else {
-		if err := s.LoadBackupFromDisk(backupFile); err != nil {
-			log.Fatalln(err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			log.Println(err)
+			if errors.Is(err, ErrUnsafePath) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			} else if errors.Is(err, ErrBackupExists) {
+				http.Error(w, err.Error(), http.StatusConflict)
+			} else {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
 			return
 		}
-	}
-
-	http.Redirect(w, r, "/backups", http.StatusSeeOther)
-}
-
-func (s *APIServer) LoadBackupFromDisk(backupFile string) error {
-
-	log.Println("loading new backup initiated")
-	currentTime := time.Now()
-
-	formattedTime := currentTime.Format("20060102_150405")
+		go describeBackups()
 
-	fileName := fmt.Sprintf("%s_%s.zip", "mcdata", formattedTime)
-
-	if err := zipit("mcdata", "backups/"+fileName, false); err != nil {
-		log.Fatalln(err)
-		return err
+		http.Redirect(w, r, "/backups", http.StatusSeeOther)
+		return
 	}
 
-	if err := removeAllFilesInDir("mcdata"); err != nil {
-		log.Fatalln(err)
-		return err
-
+	// reject bad names and unreadable formats before the server is stopped
+	if _, err := backupPath(backupFile); err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-
-	if err := unzip(fmt.Sprintf("backups/%s", backupFile), "mcdata"); err != nil {
-		log.Fatalln(err)
-		return err
-
+	if _, err := archiveFormatOf(backupFile); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	s.Runner.Containerize()
+	// restore only the selected files and directories if any were chosen
+	paths := r.Form["paths"]
 
-	return nil
+	job := s.Jobs.Run("restore", func(job *Job) error {
+		return s.LoadBackupFromDisk(backupFile, paths, job.SetStage)
+	})
+
+	http.Redirect(w, r, "/home?job="+job.ID, http.StatusSeeOther)
+	log.Printf("restore job %s created for %s\n", job.ID, backupFile)
 }
 
 func (s *APIServer) LoadBackupChooseFile(file multipart.File, backupName string) error {
 
-	// You could save the file or process it further here
-	// For example, save the file to disk
-	out, err := os.Create(fmt.Sprintf("backups/%s", backupName))
+	path, err := backupPath(backupName)
 	if err != nil {
 		return err
 	}
-	defer out.Close()
-
-	_, err = io.Copy(out, file)
-	return err
-
-	// log.Printf("File uploaded successfully")
-	// return nil
-}
-func (s *APIServer) UploadDataToCloud(backupsStrArr []string) error {
-	for _, backup := range backupsStrArr {
-		objectPath := fmt.Sprintf("backups/%s", backup)
 
-		// Check if the object already exists in GCS
-		log.Println("check if object exists", backup)
-		exists, err := s.bucket.ObjectExists(backup)
-		if err != nil {
-			log.Printf("Error checking if object exists in GCS: %v", err)
-			return err
-		}
-		if exists {
-			log.Printf("Object %s already exists in GCS. Skipping upload.", objectPath)
-			continue
-		}
-		log.Printf("uploading file %s to GCS\n", backup)
-		if err := s.bucket.UploadFileToGCS(objectPath); err != nil {
-			log.Fatalln(err)
-			return err
-		}
+	// an existing backup is never replaced, its manifest would describe another archive
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		return fmt.Errorf("%w: %s", ErrBackupExists, backupName)
 	}
-	fmt.Println("uploading data to cloud successful")
-
-	return nil
-}
-
-func (s *APIServer) DownloadDataFromCloud(backupsInCloud []string) error {
-	log.Println("getting available backups from disk")
-	backupsOnDisk, err := GetAvailableBackups("backups/")
 	if err != nil {
-		log.Fatalln(err)
 		return err
 	}
-	for _, backup := range backupsInCloud {
-		if !contains(backupsOnDisk, backup) {
-			log.Printf("downloading backup %s from cloud", backup)
-			if err := s.bucket.DownloadDataFromBucket(context.Background(), backup); err != nil {
-				log.Fatalln(err)
-				return err
-			}
-		}
+	defer out.Close()
+
+	if _, err := io.Copy(out, file); err != nil {
+		out.Close()
+		os.Remove(path)
+		return err
 	}
-	fmt.Println("downloading data to disk successful")
+	return out.Close()
 
-	return nil
+	// log.Printf("File uploaded successfully")
+	// return nil
 }
 
+// Sync runs the sync engine as a job, the backups page shows its progress and can cancel it
 func (s *APIServer) Sync(w http.ResponseWriter, r *http.Request) {
+	if s.store == nil {
+		log.Println("cloud storage is not configured, skipping sync")
+		http.Redirect(w, r, "/backups", http.StatusSeeOther)
+		return
+	}
 
-	http.Redirect(w, r, "/backups", http.StatusSeeOther)
-
-	go func() {
-
-		if s.bucket.projectID == "" || s.bucket.Name == "" {
-			return
-		}
-
-		backupsStringArr, err := GetAvailableBackups("backups/")
-		if err != nil {
-			log.Fatalln(err)
-		}
-
-		if err := s.bucket.CreateGCSBucket(); err != nil {
-			log.Println(err)
-			http.Redirect(w, r, "/backups", http.StatusInternalServerError)
-		}
-
-		// upload all files to cloud
-
-		if err := s.UploadDataToCloud(backupsStringArr); err != nil {
-			log.Fatalln(err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-		}
+	opts := SyncOptions{TriggeredBy: requestUser(r)}
+	job := s.Jobs.Run("sync", func(job *Job) error {
+		job.SetStage("syncing with " + s.store.Name())
+		opts.JobID = job.ID
+		opts.Progress = job.SetProgress
 
-		backupsInCloudStringArr, err := s.bucket.RetrieveObjectsInBucket(context.Background())
+		report, err := s.Syncer.Run(job.Context(), opts)
+		go describeBackups()
 		if err != nil {
-			log.Fatalln(err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			log.Printf("Error syncing with %s: %v", s.store.Name(), err)
+			return err
 		}
+		log.Printf("sync with %s finished, %d actions, %d backups in sync\n", s.store.Name(), len(report.Actions), report.InSync)
 
-		// upload all files to disk
-		if err := s.DownloadDataFromCloud(backupsInCloudStringArr); err != nil {
-			log.Fatalln(err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		if s.Retention.Enabled() {
+			job.SetStage("applying retention policy")
+			if _, err := s.ApplyRetention(job.Context(), true, false); err != nil {
+				log.Printf("Error applying retention policy: %v", err)
+			}
 		}
+		return nil
+	})
+	log.Printf("sync job %s created\n", job.ID)
 
-	}()
-
+	http.Redirect(w, r, "/backups?job="+job.ID, http.StatusSeeOther)
 }
 
 func (s *APIServer) LoginPage(w http.ResponseWriter, r *http.Request) {
@@ -320,6 +443,19 @@ func (s *APIServer) Login(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/home", http.StatusSeeOther)
 }
 
+// requestUser returns the user name from the request's token, it is only meaningful behind JwtAuth
+func requestUser(r *http.Request) string {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		return "unknown"
+	}
+	claims := &jwt.StandardClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(cookie.Value, claims); err != nil || claims.Issuer == "" {
+		return "unknown"
+	}
+	return claims.Issuer
+}
+
 func (s *APIServer) JwtAuth(next http.Handler) http.Handler {
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -348,146 +484,1073 @@ func (s *APIServer) JwtAuth(next http.Handler) http.Handler {
 }
 
 func (s *APIServer) Backup(w http.ResponseWriter, r *http.Request) {
-	backupName := r.FormValue("name")
-	if backupName == "" {
-		backupName = "server"
+	var format ArchiveFormat
+	if v := r.FormValue("format"); v != "" {
+		parsed, err := ParseArchiveFormat(v)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		format = parsed
 	}
-	// Respond immediately
-	http.Redirect(w, r, "/backups", http.StatusSeeOther)
 
-	// Start the backup process in the background
-	go func() {
-		// Perform backup operation here
-		currentTime := time.Now()
-		formattedTime := currentTime.Format("20060102_150405")
-		fileName := fmt.Sprintf("%s_%s.zip", backupName, formattedTime)
+	opts := BackupOptions{
+		Prefix:      r.FormValue("name"),
+		Format:      format,
+		TriggeredBy: requestUser(r),
+	}
 
-		if err := zipit("mcdata", "backups/"+fileName, false); err != nil {
+	// Start the backup process in the background
+	job := s.Jobs.Run("backup", func(job *Job) error {
+		job.SetStage("archiving")
+		if _, err := s.CreateBackup(opts); err != nil {
 			log.Println("Error during backup:", err)
+			return err
 		}
-		log.Println("Backup initiated successfully")
-	}()
+		return nil
+	})
+	log.Printf("backup job %s created\n", job.ID)
 
+	// Respond immediately
+	http.Redirect(w, r, "/backups", http.StatusSeeOther)
 }
 
-func (s *APIServer) DeleteBackup(w http.ResponseWriter, r *http.Request) {
-	backupToDelete := r.URL.Query().Get("delete")
-	removePath := fmt.Sprintf("backups/%s", backupToDelete)
-	if err := os.Remove(removePath); err != nil {
-		log.Fatalln(err)
+// runScheduledBackup is called by the scheduler for every due schedule
+func (s *APIServer) runScheduledBackup(schedule BackupSchedule) (string, error) {
+	if schedule.SkipIfStopped {
+		status, err := s.Runner.Status()
+		if err != nil {
+			return "", fmt.Errorf("failed to check server status: %v", err)
+		}
+		if !status.Running {
+			log.Printf("server is not running, skipping scheduled backup %s\n", schedule.Name)
+			return "", nil
+		}
 	}
-	log.Printf("backup %s deleted\n", backupToDelete)
 
+	opts := BackupOptions{
+		Prefix:      schedule.Prefix,
+		Format:      schedule.Format,
+		TriggeredBy: "schedule:" + schedule.Name,
+	}
+	if schedule.Incremental {
+		return s.CreateSnapshot(opts)
+	}
+	return s.CreateBackup(opts)
 }
-func (s *APIServer) BackupPage(w http.ResponseWriter, r *http.Request) {
-	path := s.TemplatePath
 
-	t, err := template.ParseFiles(path + "backups.html")
-	if err != nil {
-		log.Println(err)
-	}
+type scheduleRequest struct {
+	Name          string `json:"name"`
+	Spec          string `json:"spec"`
+	Prefix        string `json:"prefix"`
+	Format        string `json:"format"`
+	Incremental   bool   `json:"incremental"`
+	SkipIfStopped bool   `json:"skipIfStopped"`
+	Paused        bool   `json:"paused"`
+}
 
-	backupsStringArr, err := GetAvailableBackups("backups/")
-	if err != nil {
-		log.Fatalln(err)
+func (s *APIServer) ListSchedules(w http.ResponseWriter, r *http.Request) {
+	WriteJSON(w, http.StatusOK, s.Scheduler.List())
+}
+
+func (s *APIServer) AddSchedule(w http.ResponseWriter, r *http.Request) {
+	var req scheduleRequest
+	if r.Header.Get("Content-Type") == "application/json" {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			WriteJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %v", err))
+			return
+		}
+	} else {
+		req.Name = r.FormValue("name")
+		req.Spec = r.FormValue("spec")
+		req.Prefix = r.FormValue("prefix")
+		req.Format = r.FormValue("format")
+		req.Incremental = r.FormValue("incremental") != ""
+		req.SkipIfStopped = r.FormValue("skipIfStopped") != ""
+		req.Paused = r.FormValue("paused") != ""
 	}
 
-	cloudBackupsArr, err := s.bucket.RetrieveObjectsInBucket(context.Background())
+	schedule, err := s.Scheduler.Add(BackupSchedule{
+		Name:          req.Name,
+		Spec:          strings.TrimSpace(req.Spec),
+		Prefix:        req.Prefix,
+		Format:        ArchiveFormat(req.Format),
+		Incremental:   req.Incremental,
+		SkipIfStopped: req.SkipIfStopped,
+		Paused:        req.Paused,
+	})
 	if err != nil {
-		log.Fatalln("unable to download object data from cloud", err)
+		log.Printf("Error adding schedule: %v", err)
+		WriteJSONError(w, http.StatusBadRequest, err)
+		return
 	}
 
-	backups := BackupTemplateData{
-		Backups:      backupsStringArr,
-		CloudBackups: cloudBackupsArr,
-	}
+	WriteJSON(w, http.StatusCreated, schedule)
+}
 
-	t.Execute(w, backups)
+func (s *APIServer) PauseSchedule(w http.ResponseWriter, r *http.Request) {
+	s.setSchedulePaused(w, r, true)
 }
 
-func (s *APIServer) Logs(w http.ResponseWriter, r *http.Request) {
-	logsPath := s.LogsPath
-	logs, err := GetMcServerLogs(logsPath)
-	if err != nil {
-		log.Println(err)
+func (s *APIServer) ResumeSchedule(w http.ResponseWriter, r *http.Request) {
+	s.setSchedulePaused(w, r, false)
+}
+
+func (s *APIServer) setSchedulePaused(w http.ResponseWriter, r *http.Request, paused bool) {
+	if err := s.Scheduler.SetPaused(mux.Vars(r)["id"], paused); err != nil {
+		WriteJSONError(w, http.StatusNotFound, err)
+		return
 	}
+	WriteJSON(w, http.StatusOK, s.Scheduler.List())
+}
 
-	s.WriteTemplate(w, logs, "logs.html")
-	log.Println("logs accessed")
+func (s *APIServer) DeleteSchedule(w http.ResponseWriter, r *http.Request) {
+	if err := s.Scheduler.Delete(mux.Vars(r)["id"]); err != nil {
+		WriteJSONError(w, http.StatusNotFound, err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, s.Scheduler.List())
+}
 
+func (s *APIServer) DeleteBackup(w http.ResponseWriter, r *http.Request) {
+	backupToDelete := r.URL.Query().Get("delete")
+	if err := removeBackup(backupToDelete); err != nil {
+		log.Println(err)
+		if errors.Is(err, ErrUnsafePath) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "Error deleting backup", http.StatusInternalServerError)
+		return
+	}
 }
 
-func (s *APIServer) Home(w http.ResponseWriter, r *http.Request) {
-	options := []map[string]string{
-		{
-			"OptionName":  "Start Server",
-			"Description": "You can start a Minecraft Server, starting consists of creating a container in a network through Docker Engine API and initializing all needed components for the server to function properly.",
-			"APIEndpoint": "/start",
-			"Action":      "Start Server",
-			"Method":      "post",
-		},
-		{
-			"OptionName":  "Stop Server",
-			"Description": "Stopping a server removes a container and gets rid of the temporary network created while starting. Only the data created by a server (your world save) is persisted within a project directory.",
-			"APIEndpoint": "/stop",
-			"Action":      "Stop Server",
-			"Method":      "post",
-		},
-		{
-			"OptionName":  "View Logs",
-			"Description": "Log Viewer helps you browse your server's startup logs. Options to refresh page, scroll down to bottom and top are implemented.",
-			"APIEndpoint": "/logs",
-			"Action":      "Go to Log Navigator",
-			"Method":      "get",
-		},
-		{
-			"OptionName":  "Backup Server",
-			"Description": "Your server can be easily backed up if you need to. There is an option to also persist your backups in a Google Cloud Storage Bucket via \"Synchronize with Cloud\" option (keep in mind that you have to configure GCP on your own).",
-			"APIEndpoint": "/backups",
-			"Action":      "Go to Backup Manager",
-			"Method":      "get",
-		},
+// cloudErrorStatus maps errors of cloud backup actions to HTTP status codes
+func cloudErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrUnsafePath):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrObjectNotExist):
+		return http.StatusNotFound
+	case errors.Is(err, ErrSyncRunning):
+		return http.StatusConflict
+	case errors.Is(err, ErrNotSupported):
+		return http.StatusNotImplemented
+	case errors.Is(err, ErrNoStore):
+		return http.StatusServiceUnavailable
 	}
+	return http.StatusBadGateway
+}
 
-	data := map[string]interface{}{
-		"Title":   "Minecraft Server Management",
-		"Options": options,
+// ListCloudBackups describes the backups in the backup store and whether there is a local copy
+func (s *APIServer) ListCloudBackups(w http.ResponseWriter, r *http.Request) {
+	backups, err := s.CloudBackups(r.Context())
+	if err != nil {
+		log.Printf("Error listing cloud backups: %v", err)
+		WriteJSONError(w, cloudErrorStatus(err), err)
+		return
 	}
+	WriteJSON(w, http.StatusOK, backups)
+}
 
-	if err := s.WriteTemplate(w, data, "home.html"); err != nil {
-		log.Printf("Error rendering home template: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+// FetchCloudBackup downloads a single backup from the backup store to the local backups as a job
+func (s *APIServer) FetchCloudBackup(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if _, err := backupPath(name); err != nil {
+		WriteJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	if s.store == nil {
+		WriteJSONError(w, http.StatusServiceUnavailable, ErrNoStore)
 		return
 	}
 
-	log.Println("Home page accessed")
-}
-func (s *APIServer) Stop(w http.ResponseWriter, r *http.Request) {
-	// s.WriteTemplate(w, "home.html", nil)
-	http.Redirect(w, r, "/home", http.StatusSeeOther)
+	job := s.Jobs.Run("fetch", func(job *Job) error {
+		if err := s.PullCloudBackup(job.Context(), name, job.SetProgress); err != nil {
+			log.Printf("Error fetching %s from %s: %v", name, s.store.Name(), err)
+			return err
+		}
+		return nil
+	})
+	log.Printf("fetch job %s created for %s\n", job.ID, name)
 
-	go func() {
-		s.Runner.StopContainer()
-	}()
-	// WriteJSON(w, http.StatusOK, "Stop container accessed")
+	WriteJSON(w, http.StatusAccepted, job.Snapshot())
 }
 
-func (s *APIServer) Start(w http.ResponseWriter, r *http.Request) {
-	// s.WriteTemplate(w, "home.html", nil)
-	http.Redirect(w, r, "/home", http.StatusSeeOther)
+// RestoreCloudBackup restores a backup from the backup store, optionally only the selected paths
+func (s *APIServer) RestoreCloudBackup(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if _, err := backupPath(name); err != nil {
+		WriteJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	if _, err := archiveFormatOf(name); err != nil {
+		WriteJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	if s.store == nil {
+		WriteJSONError(w, http.StatusServiceUnavailable, ErrNoStore)
+		return
+	}
+	// reject missing backups before the server is stopped
+	if _, err := s.store.Stat(r.Context(), name); err != nil {
+		WriteJSONError(w, cloudErrorStatus(err), err)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		WriteJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	paths := r.Form["paths"]
+
+	job := s.Jobs.Run("restore", func(job *Job) error {
+		if err := s.LoadCloudBackup(job.Context(), name, paths, job.SetStage, job.SetProgress); err != nil {
+			log.Println("Error restoring cloud backup:", err)
+			return err
+		}
+		return nil
+	})
+	log.Printf("restore job %s created for %s from %s\n", job.ID, name, s.store.Name())
+
+	WriteJSON(w, http.StatusAccepted, job.Snapshot())
+}
+
+// SetCloudStorageClass moves a backup in the backup store to the storage class in the "class"
+// form value, without a class it is moved to the store's cold class
+func (s *APIServer) SetCloudStorageClass(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	info, err := s.ChangeCloudStorageClass(r.Context(), name, r.FormValue("class"))
+	if err != nil {
+		log.Printf("Error changing storage class of %s: %v", name, err)
+		WriteJSONError(w, cloudErrorStatus(err), err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, info)
+}
+
+// DeleteCloudBackup deletes a backup from the backup store, a local copy is kept
+func (s *APIServer) DeleteCloudBackup(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if err := s.RemoveCloudBackup(r.Context(), name); err != nil {
+		log.Printf("Error deleting cloud backup %s: %v", name, err)
+		WriteJSONError(w, cloudErrorStatus(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SyncPreview returns what a sync would do without changing anything
+func (s *APIServer) SyncPreview(w http.ResponseWriter, r *http.Request) {
+	report, err := s.Syncer.Run(r.Context(), SyncOptions{DryRun: true})
+	if err != nil {
+		if errors.Is(err, ErrSyncRunning) {
+			WriteJSONError(w, http.StatusConflict, err)
+			return
+		}
+		// the report shows the deletions the sync would refuse
+		if errors.Is(err, ErrTooManyDeletions) {
+			WriteJSON(w, http.StatusOK, report)
+			return
+		}
+		log.Printf("Error planning sync: %v", err)
+		WriteJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, report)
+}
+
+// SyncState returns the recorded state of every backup synchronized with the current store
+func (s *APIServer) SyncState(w http.ResponseWriter, r *http.Request) {
+	records, err := s.Syncer.State()
+	if err != nil {
+		WriteJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, records)
+}
+
+// SyncHistory returns past sync runs, newest first
+func (s *APIServer) SyncHistory(w http.ResponseWriter, r *http.Request) {
+	history, err := s.Syncer.History()
+	if err != nil {
+		WriteJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, history)
+}
+
+// RetentionPreview lists the local and remote backups the retention policy would prune
+func (s *APIServer) RetentionPreview(w http.ResponseWriter, r *http.Request) {
+	report, err := s.ApplyRetention(r.Context(), true, true)
+	if err != nil {
+		log.Printf("Error evaluating retention policy: %v", err)
+		WriteJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, report)
+}
+
+// GetBackupInfo returns the manifest of a local backup
+func (s *APIServer) GetBackupInfo(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	manifest, err := BackupInfo(name)
+	if os.IsNotExist(err) {
+		WriteJSONError(w, http.StatusNotFound, fmt.Errorf("backup %s not found", name))
+		return
+	}
+	if err != nil {
+		WriteJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, manifest)
+}
+
+// VerifyBackup checks the integrity of a local backup, or of the copy in the backup store with ?remote=true
+func (s *APIServer) VerifyBackup(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	remote := r.URL.Query().Get("remote") == "true"
+
+	result, err := s.Verifier.Verify(r.Context(), name, remote)
+	if os.IsNotExist(err) || errors.Is(err, ErrObjectNotExist) {
+		WriteJSONError(w, http.StatusNotFound, fmt.Errorf("backup %s not found", name))
+		return
+	}
+	if errors.Is(err, ErrUnsupportedFormat) {
+		WriteJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		log.Printf("Error verifying backup %s: %v", name, err)
+		WriteJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, result)
+}
+
+// ListVerifications returns the latest verification result of every checked backup
+func (s *APIServer) ListVerifications(w http.ResponseWriter, r *http.Request) {
+	WriteJSON(w, http.StatusOK, s.Verifier.Results())
+}
+
+func (s *APIServer) ListSnapshots(w http.ResponseWriter, r *http.Request) {
+	snapshots, err := s.Snapshots.List()
+	if err != nil {
+		WriteJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, snapshots)
+}
+
+// TakeSnapshot starts an incremental snapshot job, only files that changed since the last
+// snapshot are chunked and stored
+func (s *APIServer) TakeSnapshot(w http.ResponseWriter, r *http.Request) {
+	opts := BackupOptions{
+		Prefix:      r.FormValue("name"),
+		TriggeredBy: requestUser(r),
+	}
+	if opts.Prefix != "" && !backupPrefixRegex.MatchString(opts.Prefix) {
+		WriteJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid snapshot name %q", opts.Prefix))
+		return
+	}
+
+	job := s.Jobs.Run("snapshot", func(job *Job) error {
+		job.SetStage("chunking")
+		if _, err := s.CreateSnapshot(opts); err != nil {
+			log.Println("Error during snapshot:", err)
+			return err
+		}
+		return nil
+	})
+	log.Printf("snapshot job %s created\n", job.ID)
+
+	WriteJSON(w, http.StatusAccepted, job.Snapshot())
+}
+
+// GetSnapshot returns a snapshot including its file list
+func (s *APIServer) GetSnapshot(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	snapshot, err := s.Snapshots.Get(name)
+	if os.IsNotExist(err) {
+		WriteJSONError(w, http.StatusNotFound, fmt.Errorf("snapshot %s not found", name))
+		return
+	}
+	if err != nil {
+		WriteJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, snapshot)
+}
+
+// SnapshotContents lists the entries of a snapshot like BackupContents
+func (s *APIServer) SnapshotContents(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	entries, err := s.Snapshots.Contents(name, r.URL.Query().Get("prefix"))
+	if os.IsNotExist(err) {
+		WriteJSONError(w, http.StatusNotFound, fmt.Errorf("snapshot %s not found", name))
+		return
+	}
+	if err != nil {
+		WriteJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, entries)
+}
+
+// RestoreSnapshot restores a snapshot into mcdata, optionally only the selected paths
+func (s *APIServer) RestoreSnapshot(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if _, err := s.Snapshots.Get(name); err != nil {
+		WriteJSONError(w, http.StatusNotFound, fmt.Errorf("snapshot %s not found", name))
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		WriteJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	paths := r.Form["paths"]
+
+	job := s.Jobs.Run("restore", func(job *Job) error {
+		if err := s.LoadSnapshot(name, paths, job.SetStage); err != nil {
+			log.Println("Error restoring snapshot:", err)
+			return err
+		}
+		return nil
+	})
+	log.Printf("restore job %s created\n", job.ID)
+
+	WriteJSON(w, http.StatusAccepted, job.Snapshot())
+}
+
+func (s *APIServer) DeleteSnapshot(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	err := s.Snapshots.Delete(name)
+	if os.IsNotExist(err) {
+		WriteJSONError(w, http.StatusNotFound, fmt.Errorf("snapshot %s not found", name))
+		return
+	}
+	if err != nil {
+		WriteJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SnapshotGC removes chunks no snapshot refers to anymore, ?dryRun=true only reports them
+func (s *APIServer) SnapshotGC(w http.ResponseWriter, r *http.Request) {
+	report, err := s.Snapshots.GC(r.URL.Query().Get("dryRun") == "true")
+	if err != nil {
+		log.Printf("Error collecting snapshot chunks: %v", err)
+		WriteJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, report)
+}
+
+func (s *APIServer) BackupPage(w http.ResponseWriter, r *http.Request) {
+	path := s.TemplatePath
+
+	t, err := template.ParseFiles(path + "backups.html")
+	if err != nil {
+		log.Println(err)
+	}
+
+	backupsStringArr, err := GetAvailableBackups("backups/")
+	if err != nil {
+		log.Println(err)
+	}
+
+	cloudBackupsArr := []CloudBackup{}
+	if s.store != nil {
+		cloudBackupsArr, err = s.CloudBackups(r.Context())
+		if err != nil {
+			log.Println("unable to download object data from cloud", err)
+		}
+	}
+
+	// only saved manifests, missing ones are computed in the background
+	manifests := map[string]BackupManifest{}
+	unreadable := map[string]bool{}
+	for _, name := range backupsStringArr {
+		if manifest, ok := savedBackupInfo(name); ok {
+			manifests[name] = manifest
+		}
+		unreadable[name] = !readableBackup(name)
+	}
+	for _, backup := range cloudBackupsArr {
+		unreadable[backup.Name] = !readableBackup(backup.Name)
+	}
+
+	snapshots, err := s.Snapshots.List()
+	if err != nil {
+		log.Println("unable to list snapshots", err)
+	}
+
+	backups := BackupTemplateData{
+		Backups:       backupsStringArr,
+		Snapshots:     snapshots,
+		Manifests:     manifests,
+		Unreadable:    unreadable,
+		Verified:      s.Verifier.ResultMap(false),
+		CloudVerified: s.Verifier.ResultMap(true),
+		CloudBackups:  cloudBackupsArr,
+		Schedules:     s.Scheduler.List(),
+	}
+	if s.store != nil {
+		if backups.SyncHistory, err = s.Syncer.History(); err != nil {
+			log.Println("unable to read sync history", err)
+		}
+		records, err := s.Syncer.State()
+		if err != nil {
+			log.Println("unable to read sync state", err)
+		}
+		backups.LocalOnly = map[string]bool{}
+		for _, record := range records {
+			backups.LocalOnly[record.Name] = record.LocalOnly
+		}
+		if len(backups.SyncHistory) > 10 {
+			backups.SyncHistory = backups.SyncHistory[:10]
+		}
+	}
+
+	t.Execute(w, backups)
+}
+
+func (s *APIServer) Logs(w http.ResponseWriter, r *http.Request) {
+	logsPath := s.LogsPath
+	logs, err := GetMcServerLogs(logsPath)
+	if err != nil {
+		log.Println(err)
+	}
+
+	s.WriteTemplate(w, logs, "logs.html")
+	log.Println("logs accessed")
+
+}
+
+// LogsAPI returns a page of parsed log entries, use the returned "next" cursor as "from" and
+// "nextTime" as "fromTime" to load older entries
+func (s *APIServer) LogsAPI(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	q := LogQuery{
+		From: -1,
+		Grep: query.Get("grep"),
+	}
+
+	if v := query.Get("from"); v != "" {
+		from, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || from < 0 {
+			WriteJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid from cursor %q", v))
+			return
+		}
+		q.From = from
+	}
+	if v := query.Get("fromTime"); v != "" {
+		fromTime, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			WriteJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid fromTime %q", v))
+			return
+		}
+		q.FromTime = fromTime
+	}
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			WriteJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid limit %q", v))
+			return
+		}
+		q.Limit = limit
+	}
+	if v := query.Get("level"); v != "" {
+		q.Levels = strings.Split(v, ",")
+	}
+	if v := query.Get("since"); v != "" {
+		since, err := parseSince(v)
+		if err != nil {
+			WriteJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		q.Since = since
+	}
+
+	page, err := ReadLogPage(s.LogsPath, q)
+	if err != nil {
+		log.Printf("Error reading logs: %v", err)
+		WriteJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, page)
+}
+
+// parseSince accepts either an RFC3339 timestamp or a duration relative to now (e.g. "30m")
+func parseSince(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	if d, err := time.ParseDuration(v); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid since %q, use RFC3339 time or a duration like 30m", v)
+}
+
+type LogArchiveTemplateData struct {
+	Archives []LogArchive
+	Query    string
+	From     string
+	To       string
+	Results  []LogSearchResult
+	Error    string
+}
+
+type LogArchiveViewTemplateData struct {
+	Name  string         `json:"name"`
+	Lines []NumberedLine `json:"lines"`
+}
+
+type NumberedLine struct {
+	Number int    `json:"number"`
+	Text   string `json:"text"`
+}
+
+func numberLines(lines []string) []NumberedLine {
+	numbered := make([]NumberedLine, len(lines))
+	for i, line := range lines {
+		numbered[i] = NumberedLine{Number: i + 1, Text: line}
+	}
+	return numbered
+}
+
+func (s *APIServer) logsDir() string {
+	return filepath.Dir(s.LogsPath)
+}
+
+// searchLogArchives runs the archive search described by the q, from and to query parameters
+func (s *APIServer) searchLogArchives(r *http.Request) ([]LogSearchResult, error) {
+	query := r.URL.Query()
+	from, err := parseDate(query.Get("from"))
+	if err != nil {
+		return nil, err
+	}
+	to, err := parseDate(query.Get("to"))
+	if err != nil {
+		return nil, err
+	}
+	limit, _ := strconv.Atoi(query.Get("limit"))
+
+	return SearchLogArchives(s.logsDir(), query.Get("q"), from, to, limit)
+}
+
+func parseDate(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse("2006-01-02", v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q, use YYYY-MM-DD", v)
+	}
+	return t, nil
+}
+
+func (s *APIServer) LogArchivePage(w http.ResponseWriter, r *http.Request) {
+	archives, err := ListLogArchives(s.logsDir())
+	if err != nil {
+		log.Printf("Error listing log archives: %v", err)
+	}
+
+	query := r.URL.Query()
+	data := LogArchiveTemplateData{
+		Archives: archives,
+		Query:    query.Get("q"),
+		From:     query.Get("from"),
+		To:       query.Get("to"),
+	}
+
+	if data.Query != "" {
+		results, err := s.searchLogArchives(r)
+		if err != nil {
+			data.Error = err.Error()
+		}
+		data.Results = results
+	}
+
+	if err := s.WriteTemplate(w, data, "logarchive.html"); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	log.Println("log archive accessed")
+}
+
+func (s *APIServer) LogArchiveViewPage(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	lines, err := ReadLogArchive(s.logsDir(), name)
+	if err != nil {
+		log.Printf("Error reading log archive %s: %v", name, err)
+		http.Error(w, "Log archive not found", http.StatusNotFound)
+		return
+	}
+
+	data := LogArchiveViewTemplateData{
+		Name:  name,
+		Lines: numberLines(lines),
+	}
+	if err := s.WriteTemplate(w, data, "logarchive_view.html"); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+func (s *APIServer) ListLogArchives(w http.ResponseWriter, r *http.Request) {
+	archives, err := ListLogArchives(s.logsDir())
+	if err != nil {
+		log.Printf("Error listing log archives: %v", err)
+		WriteJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, archives)
+}
+
+func (s *APIServer) GetLogArchive(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	lines, err := ReadLogArchive(s.logsDir(), name)
+	if err != nil {
+		log.Printf("Error reading log archive %s: %v", name, err)
+		WriteJSONError(w, http.StatusNotFound, err)
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, LogArchiveViewTemplateData{Name: name, Lines: numberLines(lines)})
+}
+
+func (s *APIServer) SearchLogs(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("q") == "" {
+		WriteJSONError(w, http.StatusBadRequest, fmt.Errorf("missing search query q"))
+		return
+	}
+
+	results, err := s.searchLogArchives(r)
+	if err != nil {
+		log.Printf("Error searching log archives: %v", err)
+		WriteJSONError(w, http.StatusBadRequest, err)
+		return
+	}
 
+	WriteJSON(w, http.StatusOK, results)
+}
+
+// StreamLogs pushes new lines of the server log to the client as server-sent events
+func (s *APIServer) StreamLogs(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	// resume from the last delivered line after a reconnect, otherwise from the requested offset
+	offset := int64(-1)
+	for _, v := range []string{r.Header.Get("Last-Event-ID"), r.URL.Query().Get("offset")} {
+		if v == "" {
+			continue
+		}
+		if parsed, err := strconv.ParseInt(v, 10, 64); err == nil {
+			offset = parsed
+			break
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ctx := r.Context()
+	lines := make(chan LogLine)
+	tailErr := make(chan error, 1)
 	go func() {
-		s.Runner.Containerize()
+		tailErr <- TailLog(ctx, s.LogsPath, offset, func(line LogLine) error {
+			select {
+			case lines <- line:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
 	}()
-	// WriteJSON(w, http.StatusOK, "Start container accessed")
-	log.Println("container accessed")
+
+	keepalive := time.NewTicker(15 * time.Second)
+	defer keepalive.Stop()
+
+	log.Println("log stream opened")
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("log stream closed")
+			return
+		case err := <-tailErr:
+			if err != nil && ctx.Err() == nil {
+				log.Printf("log stream error: %v", err)
+				fmt.Fprintf(w, "event: error\ndata: %s\n\n", err)
+				flusher.Flush()
+			}
+			return
+		case line := <-lines:
+			if line.Rotated {
+				fmt.Fprintf(w, "event: rotate\ndata: log file rotated\n\n")
+			}
+			fmt.Fprintf(w, "id: %d\ndata: %s\n\n", line.Offset, line.Text)
+			flusher.Flush()
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+func (s *APIServer) Home(w http.ResponseWriter, r *http.Request) {
+	options := []map[string]string{
+		{
+			"OptionName":  "Start Server",
+			"Description": "You can start a Minecraft Server, starting consists of creating a container in a network through Docker Engine API and initializing all needed components for the server to function properly.",
+			"APIEndpoint": "/start",
+			"Action":      "Start Server",
+			"Method":      "post",
+		},
+		{
+			"OptionName":  "Stop Server",
+			"Description": "Stopping a server removes a container and gets rid of the temporary network created while starting. Only the data created by a server (your world save) is persisted within a project directory.",
+			"APIEndpoint": "/stop",
+			"Action":      "Stop Server",
+			"Method":      "post",
+		},
+		{
+			"OptionName":  "View Logs",
+			"Description": "Log Viewer helps you browse your server's startup logs. New log lines are streamed live, options to scroll down to bottom and top are implemented.",
+			"APIEndpoint": "/logs",
+			"Action":      "Go to Log Navigator",
+			"Method":      "get",
+		},
+		{
+			"OptionName":  "Server Console",
+			"Description": "Run Minecraft commands on the running server through its remote console (RCON). Commands and responses from your session are kept in the console history.",
+			"APIEndpoint": "/console",
+			"Action":      "Open Console",
+			"Method":      "get",
+		},
+		{
+			"OptionName":  "Backup Server",
+			"Description": "Your server can be easily backed up if you need to. There is an option to also persist your backups in a Google Cloud Storage Bucket via \"Synchronize with Cloud\" option (keep in mind that you have to configure GCP on your own).",
+			"APIEndpoint": "/backups",
+			"Action":      "Go to Backup Manager",
+			"Method":      "get",
+		},
+	}
+
+	status, err := s.Runner.Status()
+	if err != nil {
+		log.Printf("Error inspecting container: %v", err)
+	}
+
+	data := map[string]interface{}{
+		"Title":       "Minecraft Server Management",
+		"Options":     options,
+		"Status":      status,
+		"StatusError": err,
+		"JobID":       r.URL.Query().Get("job"),
+	}
+
+	if err := s.WriteTemplate(w, data, "home.html"); err != nil {
+		log.Printf("Error rendering home template: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	log.Println("Home page accessed")
+}
+func (s *APIServer) ContainerStatus(w http.ResponseWriter, r *http.Request) {
+	status, err := s.Runner.Status()
+	if err != nil {
+		log.Printf("Error inspecting container: %v", err)
+		WriteJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, status)
+}
+
+func (s *APIServer) ConsolePage(w http.ResponseWriter, r *http.Request) {
+	history := s.Console.Get(sessionID(r))
+	if err := s.WriteTemplate(w, history, "console.html"); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	log.Println("console accessed")
+}
+
+func (s *APIServer) ConsoleHistory(w http.ResponseWriter, r *http.Request) {
+	WriteJSON(w, http.StatusOK, s.Console.Get(sessionID(r)))
+}
+
+type consoleRequest struct {
+	Command string `json:"command"`
+}
+
+func (s *APIServer) ConsoleCommand(w http.ResponseWriter, r *http.Request) {
+	var req consoleRequest
+	if r.Header.Get("Content-Type") == "application/json" {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			WriteJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %v", err))
+			return
+		}
+	} else {
+		req.Command = r.FormValue("command")
+	}
+
+	cmd := strings.TrimPrefix(strings.TrimSpace(req.Command), "/")
+	if cmd == "" {
+		WriteJSONError(w, http.StatusBadRequest, fmt.Errorf("command is empty"))
+		return
+	}
+
+	rcon, err := s.Runner.RCON()
+	if err != nil {
+		log.Printf("console unavailable: %v", err)
+		WriteJSONError(w, http.StatusServiceUnavailable, fmt.Errorf("server console unavailable: %v", err))
+		return
+	}
+	defer rcon.Close()
+
+	entry := s.RunConsoleCommand(rcon, sessionID(r), cmd)
+	log.Printf("console command %q executed\n", cmd)
+
+	WriteJSON(w, http.StatusOK, entry)
+}
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+func (s *APIServer) ConsoleWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("websocket upgrade failed: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	rcon, err := s.Runner.RCON()
+	if err != nil {
+		log.Printf("console unavailable: %v", err)
+		conn.WriteJSON(ConsoleEntry{Error: fmt.Sprintf("server console unavailable: %v", err), Time: time.Now()})
+		return
+	}
+	defer rcon.Close()
+
+	session := sessionID(r)
+	for {
+		var req consoleRequest
+		if err := conn.ReadJSON(&req); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Printf("websocket read error: %v", err)
+			}
+			return
+		}
+
+		cmd := strings.TrimPrefix(strings.TrimSpace(req.Command), "/")
+		if cmd == "" {
+			continue
+		}
+
+		entry := s.RunConsoleCommand(rcon, session, cmd)
+		log.Printf("console command %q executed\n", cmd)
+		if err := conn.WriteJSON(entry); err != nil {
+			log.Printf("websocket write error: %v", err)
+			return
+		}
+	}
+}
+
+// sessionID identifies a login session by the hash of its token cookie
+func sessionID(r *http.Request) string {
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(cookie.Value))
+	return hex.EncodeToString(sum[:8])
+}
+
+func (s *APIServer) ListJobs(w http.ResponseWriter, r *http.Request) {
+	WriteJSON(w, http.StatusOK, s.Jobs.List())
+}
+
+func (s *APIServer) GetJob(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	job, ok := s.Jobs.Get(id)
+	if !ok {
+		WriteJSONError(w, http.StatusNotFound, fmt.Errorf("job %s not found", id))
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, job.Snapshot())
+}
+
+// CancelJob asks a running job to stop, only jobs that support it (e.g. sync) react to it
+func (s *APIServer) CancelJob(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	job, ok := s.Jobs.Get(id)
+	if !ok {
+		WriteJSONError(w, http.StatusNotFound, fmt.Errorf("job %s not found", id))
+		return
+	}
+	if err := s.Jobs.Cancel(id); err != nil {
+		WriteJSONError(w, http.StatusConflict, err)
+		return
+	}
+	WriteJSON(w, http.StatusAccepted, job.Snapshot())
+}
+
+// lockServer takes the lifecycle lock, waiting for a running backup, snapshot, restore, start or
+// stop to finish first. It returns the function releasing the lock.
+func (s *APIServer) lockServer(progress ProgressFunc) func() {
+	if !s.lifecycle.TryLock() {
+		progress.report("waiting for another backup, restore, start or stop to finish")
+		s.lifecycle.Lock()
+	}
+	return s.lifecycle.Unlock
+}
+
+func (s *APIServer) Stop(w http.ResponseWriter, r *http.Request) {
+	job := s.Jobs.Run("stop", func(job *Job) error {
+		defer s.lockServer(job.SetStage)()
+		return s.Runner.StopContainer(job.SetStage)
+	})
+
+	http.Redirect(w, r, "/home?job="+job.ID, http.StatusSeeOther)
+	log.Printf("stop job %s created\n", job.ID)
+}
+
+func (s *APIServer) Start(w http.ResponseWriter, r *http.Request) {
+	job := s.Jobs.Run("start", func(job *Job) error {
+		defer s.lockServer(job.SetStage)()
+		return s.Runner.Containerize(job.SetStage)
+	})
+
+	http.Redirect(w, r, "/home?job="+job.ID, http.StatusSeeOther)
+	log.Printf("start job %s created\n", job.ID)
 }
 
 func WriteJSON(w http.ResponseWriter, status int, v any) {
-	w.WriteHeader(status)
 	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(status)
 	fmt.Printf("%v Request Status: %d \n", time.Now().UTC(), status)
-	w.Write([]byte(fmt.Sprintf("Status: %v", status)))
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error encoding JSON response: %v", err)
+	}
+}
+
+func WriteJSONError(w http.ResponseWriter, status int, err error) {
+	WriteJSON(w, status, map[string]string{"error": err.Error()})
 }
 
 func (s *APIServer) WriteTemplate2(w http.ResponseWriter, site string, v any) {
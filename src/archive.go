@@ -0,0 +1,508 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ArchiveFormat is the file format of a backup, it is also the backup's file extension
+type ArchiveFormat string
+
+const (
+	FormatZip    ArchiveFormat = "zip"
+	FormatTarGz  ArchiveFormat = "tar.gz"
+	FormatTarZst ArchiveFormat = "tar.zst"
+)
+
+var archiveFormats = []ArchiveFormat{FormatZip, FormatTarGz, FormatTarZst}
+
+// ErrUnsupportedFormat is returned for backups in formats older versions accepted (gz, bz2, 7z, xz),
+// they are still listed, downloaded, synced and deleted but cannot be read
+var ErrUnsupportedFormat = errors.New("unsupported backup format")
+
+// ParseArchiveFormat validates a format name, an empty name selects zip
+func ParseArchiveFormat(name string) (ArchiveFormat, error) {
+	if name == "" {
+		return FormatZip, nil
+	}
+	for _, format := range archiveFormats {
+		if string(format) == name {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("unknown backup format %q, use zip, tar.gz or tar.zst", name)
+}
+
+// archiveFormatOf returns the format of a backup file from its extension
+func archiveFormatOf(name string) (ArchiveFormat, error) {
+	for _, format := range archiveFormats {
+		if strings.HasSuffix(name, "."+string(format)) {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("%w of %q, it can only be downloaded or deleted", ErrUnsupportedFormat, filepath.Base(name))
+}
+
+// readableBackup reports whether the archive format of a backup can be read (restored, verified, listed)
+func readableBackup(name string) bool {
+	_, err := archiveFormatOf(name)
+	return err == nil
+}
+
+// archiveEntry is a file or directory read from an archive
+type archiveEntry struct {
+	Name    string // slash separated, without leading "/" or trailing "/", for matching and listing only
+	RawName string // name as stored in the archive, untrusted, used to build extraction paths
+	Mode    os.FileMode
+	Size    int64
+	Dir     bool
+	Symlink bool
+}
+
+type archiveWriter interface {
+	addDir(name string, info os.FileInfo) error
+	addFile(name string, info os.FileInfo, r io.Reader) error
+	Close() error
+}
+
+type zipArchiveWriter struct {
+	zw *zip.Writer
+}
+
+func (z *zipArchiveWriter) addDir(name string, info os.FileInfo) error {
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name + "/"
+	_, err = z.zw.CreateHeader(header)
+	return err
+}
+
+func (z *zipArchiveWriter) addFile(name string, info os.FileInfo, r io.Reader) error {
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	header.Method = zip.Deflate
+
+	writer, err := z.zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(writer, r)
+	return err
+}
+
+func (z *zipArchiveWriter) Close() error {
+	return z.zw.Close()
+}
+
+// tarArchiveWriter writes a tar stream through a compressor
+type tarArchiveWriter struct {
+	tw         *tar.Writer
+	compressor io.WriteCloser
+}
+
+func (t *tarArchiveWriter) addDir(name string, info os.FileInfo) error {
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = name + "/"
+	return t.tw.WriteHeader(header)
+}
+
+func (t *tarArchiveWriter) addFile(name string, info os.FileInfo, r io.Reader) error {
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if err := t.tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.Copy(t.tw, r)
+	return err
+}
+
+func (t *tarArchiveWriter) Close() error {
+	if err := t.tw.Close(); err != nil {
+		t.compressor.Close()
+		return err
+	}
+	return t.compressor.Close()
+}
+
+func newArchiveWriter(w io.Writer, format ArchiveFormat) (archiveWriter, error) {
+	switch format {
+	case FormatZip:
+		return &zipArchiveWriter{zw: zip.NewWriter(w)}, nil
+	case FormatTarGz:
+		gz := gzip.NewWriter(w)
+		return &tarArchiveWriter{tw: tar.NewWriter(gz), compressor: gz}, nil
+	case FormatTarZst:
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, err
+		}
+		return &tarArchiveWriter{tw: tar.NewWriter(zw), compressor: zw}, nil
+	default:
+		return nil, fmt.Errorf("unsupported backup format %q", format)
+	}
+}
+
+// createArchive archives the contents of the source directory (without the directory itself)
+// into target and returns the number of files written. The format is taken from target's extension.
+// src code credits: https://gist.github.com/yhirose/addb8d248825d373095c
+func createArchive(source, target string) (int, error) {
+	format, err := archiveFormatOf(target)
+	if err != nil {
+		return 0, err
+	}
+
+	// the archive only appears under its name once it is complete, so a half written backup is
+	// never listed, synced, verified or downloaded
+	tmp := target + ".part"
+	defer os.Remove(tmp)
+	count, err := writeArchive(source, tmp, format)
+	if err != nil {
+		return 0, err
+	}
+	// unlike a rename, a link never replaces a backup that already has the name
+	if err := os.Link(tmp, target); os.IsExist(err) {
+		return 0, fmt.Errorf("%w: %s", ErrBackupExists, filepath.Base(target))
+	} else if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func writeArchive(source, target string, format ArchiveFormat) (int, error) {
+	file, err := os.Create(target)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	var out io.Writer = file
+	var encrypter io.WriteCloser
+	if backupKeys.CurrentKeyID() != "" {
+		if encrypter, err = backupKeys.newEncryptingWriter(file); err != nil {
+			return 0, err
+		}
+		out = encrypter
+	}
+
+	archive, err := newArchiveWriter(out, format)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	err = filepath.Walk(source, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(source, p)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		name := filepath.ToSlash(rel)
+
+		if info.IsDir() {
+			return archive.addDir(name, info)
+		}
+		if !info.Mode().IsRegular() {
+			log.Printf("skipping %s, not a regular file\n", p)
+			return nil
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		count++
+		return archive.addFile(name, info, f)
+	})
+	if err != nil {
+		archive.Close()
+		return 0, err
+	}
+
+	if err := archive.Close(); err != nil {
+		return 0, err
+	}
+	if encrypter != nil {
+		if err := encrypter.Close(); err != nil {
+			return 0, err
+		}
+	}
+	return count, file.Close()
+}
+
+// walkArchive calls fn for every entry of the archive, r reads the content of files and is nil
+// for other entries. Reading an entry to its end validates its checksum where the format has one
+// (CRC-32 per zip entry, gzip and zstd checksums of the whole tar stream are checked at the end).
+// Encrypted backups are decrypted on the fly.
+func walkArchive(archivePath string, fn func(entry archiveEntry, r io.Reader) error) error {
+	format, err := archiveFormatOf(archivePath)
+	if err != nil {
+		return err
+	}
+
+	file, err := openBackupFile(archivePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if format == FormatZip {
+		return walkZip(file, fn)
+	}
+
+	var stream io.Reader
+	switch format {
+	case FormatTarGz:
+		gz, err := gzip.NewReader(file.Reader())
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		stream = gz
+	case FormatTarZst:
+		zr, err := zstd.NewReader(file.Reader())
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		stream = zr
+	}
+
+	tr := tar.NewReader(stream)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		entry := archiveEntry{
+			Name:    cleanEntryName(header.Name),
+			RawName: header.Name,
+			Mode:    header.FileInfo().Mode(),
+			Size:    header.Size,
+			Dir:     header.Typeflag == tar.TypeDir,
+			Symlink: header.Typeflag == tar.TypeSymlink || header.Typeflag == tar.TypeLink,
+		}
+		var r io.Reader
+		if header.Typeflag == tar.TypeReg {
+			r = tr
+		}
+		if err := fn(entry, r); err != nil {
+			return err
+		}
+	}
+
+	// read the rest of the compressed stream so its checksum is verified
+	_, err = io.Copy(io.Discard, stream)
+	return err
+}
+
+func walkZip(file *backupFile, fn func(entry archiveEntry, r io.Reader) error) error {
+	reader, err := zip.NewReader(file, file.Size)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range reader.File {
+		entry := archiveEntry{
+			Name:    cleanEntryName(file.Name),
+			RawName: file.Name,
+			Mode:    file.Mode(),
+			Size:    int64(file.UncompressedSize64),
+			Dir:     file.FileInfo().IsDir(),
+			Symlink: file.Mode()&os.ModeSymlink != 0,
+		}
+		if entry.Dir || entry.Symlink {
+			if err := fn(entry, nil); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := func() error {
+			rc, err := file.Open()
+			if err != nil {
+				return err
+			}
+			defer rc.Close()
+			return fn(entry, rc)
+		}(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cleanEntryName normalizes an entry name for matching. It turns "../x" into "x", so it must
+// never be used to build paths, extraction joins the raw name with safeJoin.
+func cleanEntryName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// extractArchivePaths extracts only the entries at or below the given slash separated paths,
+// all entries are extracted if paths is empty
+func extractArchivePaths(archive, target string, paths []string) error {
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return err
+	}
+
+	return walkArchive(archive, func(entry archiveEntry, r io.Reader) error {
+		// the archive root itself, e.g. "./" in tars
+		if path.Clean(entry.RawName) == "." {
+			return nil
+		}
+		if len(paths) > 0 && !underAny(entry.Name, paths) {
+			return nil
+		}
+
+		if entry.Symlink {
+			return fmt.Errorf("%w: archive entry %q is a link", ErrUnsafePath, entry.RawName)
+		}
+
+		dest, err := safeJoin(target, entry.RawName)
+		if err != nil {
+			return err
+		}
+
+		if entry.Dir {
+			return os.MkdirAll(dest, 0755)
+		}
+		if r == nil {
+			log.Printf("skipping archive entry %s, not a regular file\n", entry.RawName)
+			return nil
+		}
+		return extractFile(r, dest, entry.Mode)
+	})
+}
+
+func extractFile(r io.Reader, path string, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	// never write through an existing symlink
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		return fmt.Errorf("%w: %q is a symlink", ErrUnsafePath, path)
+	}
+
+	targetFile, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+	defer targetFile.Close()
+
+	if _, err := io.Copy(targetFile, r); err != nil {
+		return err
+	}
+	return targetFile.Close()
+}
+
+// underAny reports whether the archive entry name is one of paths or inside one of them
+func underAny(name string, paths []string) bool {
+	name = cleanEntryName(name)
+	for _, p := range paths {
+		if name == p || strings.HasPrefix(name, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// ArchiveEntry is a file or directory of a backup, directories are summed up
+type ArchiveEntry struct {
+	Path  string `json:"path"`
+	Dir   bool   `json:"dir"`
+	Size  int64  `json:"size"`  // uncompressed size
+	Files int    `json:"files"` // number of files (1 for a file)
+}
+
+// ListArchiveContents returns the direct children of prefix ("" for the top level) in a backup
+func ListArchiveContents(archive, prefix string) ([]ArchiveEntry, error) {
+	prefix = strings.Trim(prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	entries := map[string]*ArchiveEntry{}
+	var order []string
+	err := walkArchive(archive, func(file archiveEntry, r io.Reader) error {
+		name := file.Name
+		if name == "" || !strings.HasPrefix(name, prefix) {
+			return nil
+		}
+
+		rest := strings.TrimPrefix(name, prefix)
+		child, _, isNested := strings.Cut(rest, "/")
+		isDir := isNested || file.Dir
+
+		entry, ok := entries[child]
+		if !ok {
+			entry = &ArchiveEntry{Path: prefix + child, Dir: isDir}
+			entries[child] = entry
+			order = append(order, child)
+		}
+		if isDir {
+			entry.Dir = true
+		}
+		if !file.Dir {
+			entry.Size += file.Size
+			entry.Files++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(order)
+	result := make([]ArchiveEntry, 0, len(order))
+	for _, child := range order {
+		result = append(result, *entries[child])
+	}
+	return result, nil
+}
+
+// archiveFileCount returns the number of regular files in a backup
+func archiveFileCount(archive string) (int, error) {
+	count := 0
+	err := walkArchive(archive, func(entry archiveEntry, r io.Reader) error {
+		if r != nil {
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
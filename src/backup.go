@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+const backupsDir = "backups"
+
+var ErrBackupExists = errors.New("a backup with this name already exists")
+
+// saveFlushTimeout is how long a backup waits for the server to write all chunks to disk
+const saveFlushTimeout = 2 * time.Minute
+
+var backupPrefixRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+type BackupOptions struct {
+	Prefix      string        // backup name prefix, the timestamp is appended to it
+	Format      ArchiveFormat // archive format, the server's default format if empty
+	TriggeredBy string        // user or schedule that requested the backup
+}
+
+// CreateBackup archives mcdata into backups/<prefix>_<timestamp>.<format> and returns the file name
+func (s *APIServer) CreateBackup(opts BackupOptions) (string, error) {
+	if opts.Prefix == "" {
+		opts.Prefix = "server"
+	}
+	if !backupPrefixRegex.MatchString(opts.Prefix) {
+		return "", fmt.Errorf("invalid backup name %q, use letters, digits, '-' and '_' only", opts.Prefix)
+	}
+
+	if opts.Format == "" {
+		opts.Format = s.BackupFormat
+	}
+	if _, err := ParseArchiveFormat(string(opts.Format)); err != nil {
+		return "", err
+	}
+
+	// the name and manifest are taken once the lock is held, so they describe the data archived
+	defer s.lockServer(nil)()
+	name, err := timestampedName(opts.Prefix, func(name string) (bool, error) {
+		return exists(filepath.Join(backupsDir, name+"."+string(opts.Format)))
+	})
+	if err != nil {
+		return "", err
+	}
+	fileName := name + "." + string(opts.Format)
+
+	manifest := BackupManifest{
+		Name:        fileName,
+		Created:     time.Now(),
+		Format:      opts.Format,
+		Image:       s.Runner.Image,
+		TriggeredBy: opts.TriggeredBy,
+	}
+
+	log.Printf("creating backup %s (triggered by %s)\n", fileName, opts.TriggeredBy)
+	var fileCount int
+	state, archiveErr := s.consistentCopy(func() error {
+		var err error
+		fileCount, err = createArchive(dataDir, filepath.Join(backupsDir, fileName))
+		return err
+	})
+	manifest.ServerRunning = state.ServerRunning
+	manifest.Consistent = state.Consistent
+	manifest.Warnings = state.Warnings
+	manifest.ImageDigest = state.ImageDigest
+	manifest.Seed = state.Seed
+	manifest.MinecraftVersion = state.MinecraftVersion
+
+	if archiveErr != nil {
+		return "", fmt.Errorf("failed to create backup %s: %v", fileName, archiveErr)
+	}
+	manifest.FileCount = fileCount
+	log.Printf("backup %s created\n", fileName)
+
+	if err := fillArchiveInfo(&manifest, filepath.Join(backupsDir, fileName)); err != nil {
+		log.Printf("Error reading backup %s: %v", fileName, err)
+	}
+	if err := writeManifest(manifest); err != nil {
+		log.Printf("Error writing manifest for %s: %v", fileName, err)
+	}
+
+	if s.Retention.Enabled() {
+		if _, err := s.ApplyRetention(context.Background(), false, false); err != nil {
+			log.Printf("Error applying retention policy: %v", err)
+		}
+	}
+
+	return fileName, nil
+}
+
+// timestampedName returns <prefix>_<timestamp> for the current second. Names have a resolution of
+// one second, while taken reports the name as used it waits for the next second.
+func timestampedName(prefix string, taken func(name string) (bool, error)) (string, error) {
+	for {
+		now := time.Now()
+		name := fmt.Sprintf("%s_%s", prefix, now.Format("20060102_150405"))
+		used, err := taken(name)
+		if err != nil {
+			return "", err
+		}
+		if !used {
+			return name, nil
+		}
+		time.Sleep(now.Truncate(time.Second).Add(time.Second).Sub(now))
+	}
+}
+
+// CreateSnapshot stores an incremental snapshot of mcdata named <prefix>_<timestamp> in the chunk store
+func (s *APIServer) CreateSnapshot(opts BackupOptions) (string, error) {
+	if opts.Prefix == "" {
+		opts.Prefix = "snapshot"
+	}
+	if !backupPrefixRegex.MatchString(opts.Prefix) {
+		return "", fmt.Errorf("invalid snapshot name %q, use letters, digits, '-' and '_' only", opts.Prefix)
+	}
+
+	defer s.lockServer(nil)()
+	name, err := timestampedName(opts.Prefix, s.Snapshots.Exists)
+	if err != nil {
+		return "", err
+	}
+	snapshot := Snapshot{
+		Name:        name,
+		Created:     time.Now(),
+		TriggeredBy: opts.TriggeredBy,
+	}
+
+	log.Printf("creating snapshot %s (triggered by %s)\n", name, opts.TriggeredBy)
+	state, err := s.consistentCopy(func() error {
+		var err error
+		snapshot, err = s.Snapshots.Create(dataDir, snapshot)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create snapshot %s: %v", name, err)
+	}
+	log.Printf("snapshot %s created, %d files, %d new chunks (%d bytes)\n", name, snapshot.FileCount, snapshot.NewChunks, snapshot.NewBytes)
+
+	snapshot.ServerRunning = state.ServerRunning
+	snapshot.Consistent = state.Consistent
+	snapshot.Warnings = state.Warnings
+	snapshot.Seed = state.Seed
+	snapshot.MinecraftVersion = state.MinecraftVersion
+	if err := s.Snapshots.Update(snapshot); err != nil {
+		log.Printf("Error updating snapshot %s: %v", name, err)
+	}
+
+	if s.Retention.Enabled() {
+		if _, err := s.ApplyRetention(context.Background(), false, false); err != nil {
+			log.Printf("Error applying retention policy: %v", err)
+		}
+	}
+
+	return name, nil
+}
+
+// copyState describes the server while mcdata was copied
+type copyState struct {
+	ServerRunning    bool
+	Consistent       bool // world saving was paused while copying (or the server was stopped)
+	Warnings         []string
+	ImageDigest      string
+	Seed             string
+	MinecraftVersion string
+}
+
+// consistentCopy runs copy on mcdata, if the server is running world saving is paused over RCON
+// for the duration of copy. Without RCON copy still runs and the state carries a warning.
+func (s *APIServer) consistentCopy(copy func() error) (copyState, error) {
+	state := copyState{Consistent: true}
+
+	status, err := s.Runner.Status()
+	if err != nil {
+		state.Consistent = false
+		state.Warnings = append(state.Warnings, fmt.Sprintf("could not check server status: %v", err))
+	}
+	state.ServerRunning = status.Running
+	state.ImageDigest = status.Digest
+
+	// a running server keeps writing region files, pause saving so the copy is consistent
+	var rcon *RCONClient
+	if status.Running {
+		rcon, err = s.pauseSaving()
+		if err != nil {
+			state.Consistent = false
+			state.Warnings = append(state.Warnings, fmt.Sprintf("backup taken while the server was writing, world may be inconsistent: %v", err))
+			log.Printf("WARNING: hot backup without save-off: %v", err)
+		}
+	}
+
+	state.Seed = worldSeed(rcon)
+	state.MinecraftVersion = minecraftVersion(s.LogsPath)
+
+	copyErr := copy()
+
+	if rcon != nil {
+		if err := s.resumeSaving(rcon); err != nil {
+			state.Warnings = append(state.Warnings, fmt.Sprintf("failed to re-enable saving: %v", err))
+			log.Printf("WARNING: failed to re-enable world saving, run save-on manually: %v", err)
+		}
+	}
+
+	return state, copyErr
+}
+
+// pauseSaving disables automatic world saving and flushes pending chunks to disk,
+// the returned connection has to be passed to resumeSaving
+func (s *APIServer) pauseSaving() (*RCONClient, error) {
+	rcon, err := s.Runner.RCON()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, cmd := range []string{"save-off", "save-all flush"} {
+		// flushing a large world takes longer than other commands
+		timeout := rconTimeout
+		if cmd == "save-all flush" {
+			timeout = saveFlushTimeout
+		}
+		resp, err := rcon.CommandTimeout(cmd, timeout)
+		if err != nil {
+			// make sure saving is not left disabled
+			if err := s.resumeSaving(rcon); err != nil {
+				log.Printf("WARNING: failed to re-enable world saving, run save-on manually: %v", err)
+			}
+			return nil, fmt.Errorf("rcon command %q failed: %v", cmd, err)
+		}
+		log.Printf("rcon %q: %s\n", cmd, resp)
+	}
+
+	return rcon, nil
+}
+
+// resumeSaving re-enables automatic world saving, reconnecting once if the connection was lost
+func (s *APIServer) resumeSaving(rcon *RCONClient) error {
+	_, err := rcon.Command("save-on")
+	rcon.Close()
+	if err == nil {
+		return nil
+	}
+
+	rcon, err = s.Runner.RCON()
+	if err != nil {
+		return err
+	}
+	defer rcon.Close()
+	_, err = rcon.Command("save-on")
+	return err
+}
+
+// removeBackup deletes a local backup together with its manifest
+func removeBackup(name string) error {
+	path, err := backupPath(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	if err := os.Remove(manifestPath(name)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Error removing manifest of %s: %v", name, err)
+	}
+	log.Printf("backup %s deleted\n", name)
+	return nil
+}
+
+// ApplyRetention evaluates the retention policy against local backups and, if remote is set,
+// against the backup store. With dryRun nothing is deleted, the report lists what would be pruned.
+func (s *APIServer) ApplyRetention(ctx context.Context, remote bool, dryRun bool) (RetentionReport, error) {
+	report := RetentionReport{
+		Policy: s.Retention,
+		DryRun: dryRun,
+	}
+
+	localBackups, err := GetAvailableBackups(backupsDir)
+	if err != nil {
+		return report, err
+	}
+	report.Local = s.Retention.Plan(localBackups)
+
+	snapshots, err := s.Snapshots.Names()
+	if err != nil {
+		return report, err
+	}
+	if len(snapshots) > 0 {
+		plan := s.Retention.Plan(snapshots)
+		report.Snapshots = &plan
+	}
+
+	if remote && s.store != nil {
+		remoteBackups, err := s.store.List(ctx)
+		if err != nil {
+			return report, fmt.Errorf("failed to list %s: %v", s.store.Name(), err)
+		}
+		plan := s.Retention.Plan(remoteBackups)
+		report.Remote = &plan
+	}
+
+	if dryRun {
+		return report, nil
+	}
+
+	for _, name := range report.Local.Prune {
+		log.Printf("retention: pruning local backup %s\n", name)
+		if err := removeBackup(name); err != nil {
+			report.Errors = append(report.Errors, err.Error())
+		}
+	}
+	if report.Snapshots != nil && len(report.Snapshots.Prune) > 0 {
+		for _, name := range report.Snapshots.Prune {
+			log.Printf("retention: pruning snapshot %s\n", name)
+			if err := s.Snapshots.Delete(name); err != nil {
+				report.Errors = append(report.Errors, err.Error())
+			}
+		}
+		gc, err := s.Snapshots.GC(false)
+		if err != nil {
+			report.Errors = append(report.Errors, err.Error())
+		}
+		report.GC = &gc
+	}
+	if report.Remote != nil {
+		for _, name := range report.Remote.Prune {
+			log.Printf("retention: pruning %s from %s\n", name, s.store.Name())
+			if err := s.store.Delete(ctx, name); err != nil {
+				report.Errors = append(report.Errors, err.Error())
+			}
+		}
+	}
+
+	if len(report.Errors) > 0 {
+		return report, fmt.Errorf("retention finished with %d errors", len(report.Errors))
+	}
+	return report, nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestTimestampedNameSkipsTakenNames moves on to the next second while a name is taken
+func TestTimestampedNameSkipsTakenNames(t *testing.T) {
+	taken := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		name, err := timestampedName("world", func(name string) (bool, error) {
+			return taken[name], nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if taken[name] {
+			t.Fatalf("%s was returned twice", name)
+		}
+		if _, _, ok := parseBackupName(name + ".zip"); !ok {
+			t.Errorf("%s is not a backup name", name)
+		}
+		taken[name] = true
+	}
+}
+
+// TestCreateArchiveKeepsExistingBackup refuses to replace a backup created in the same second
+func TestCreateArchiveKeepsExistingBackup(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "mcdata")
+	if err := os.MkdirAll(source, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(source, "level.dat"), []byte("level"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	target := filepath.Join(dir, "world_20240101_000000.zip")
+	if err := os.WriteFile(target, []byte("first backup"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := createArchive(source, target); !errors.Is(err, ErrBackupExists) {
+		t.Fatalf("createArchive = %v, want ErrBackupExists", err)
+	}
+	if body, err := os.ReadFile(target); err != nil || string(body) != "first backup" {
+		t.Errorf("existing backup is %q, %v", body, err)
+	}
+	if ok, _ := exists(target + ".part"); ok {
+		t.Error("partial archive left behind")
+	}
+}
@@ -2,45 +2,150 @@ package main
 
 import (
 	"context"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
+	"sync"
 
 	storage "cloud.google.com/go/storage"
 	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
 )
 
+// uploadChunkSize is the size of the chunks of a resumable upload, a failed chunk is retried
+// without sending the whole file again
+const uploadChunkSize = 8 << 20
+
+// coldStorageClass is used when moving a backup to cold storage without choosing a class, it
+// is cheaper to keep than STANDARD but can still be downloaded right away
+const coldStorageClass = "COLDLINE"
+
+// Bucket is the Google Cloud Storage implementation of BackupStore
 type Bucket struct {
-	Name      string
-	projectID string
-	isPrivate bool
+	BucketName string
+	projectID  string
+	isPrivate  bool
+
+	mu     sync.Mutex
+	client *storage.Client // shared by all requests, created on first use
+	opts   []option.ClientOption
 }
 
-func NewBucket(bucketName string, projectID string) (*Bucket, error) {
+// NewBucket creates a store for the bucket, opts configure the client (e.g. option.WithEndpoint).
+// The client talks to the emulator at STORAGE_EMULATOR_HOST without credentials if it is set.
+func NewBucket(bucketName string, projectID string, opts ...option.ClientOption) (*Bucket, error) {
+	if bucketName == "" || projectID == "" {
+		return nil, fmt.Errorf("BACKUPS_BUCKET and PROJECT_ID have to be set")
+	}
 	return &Bucket{
-		Name:      bucketName,
-		projectID: projectID,
-		isPrivate: true,
+		BucketName: bucketName,
+		projectID:  projectID,
+		isPrivate:  true,
+		opts:       opts,
 	}, nil
 }
 
+// NewBucketWithClient creates a store that uses an existing client, e.g. one connected to a fake server
+func NewBucketWithClient(bucketName string, projectID string, client *storage.Client) (*Bucket, error) {
+	b, err := NewBucket(bucketName, projectID)
+	if err != nil {
+		return nil, err
+	}
+	b.client = client
+	return b, nil
+}
+
+func (b *Bucket) Name() string {
+	return "gs://" + b.BucketName
+}
+
+// storageClient returns the shared client, it is safe for concurrent use
+func (b *Bucket) storageClient() (*storage.Client, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.client == nil {
+		client, err := storage.NewClient(context.Background(), b.opts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create GCS client: %v", err)
+		}
+		b.client = client
+	}
+	return b.client, nil
+}
+
+// Close releases the client, the next request creates a new one
+func (b *Bucket) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.client == nil {
+		return nil
+	}
+	err := b.client.Close()
+	b.client = nil
+	return err
+}
+
+func (b *Bucket) Prepare(ctx context.Context) error {
+	return b.CreateGCSBucket(ctx)
+}
+
+func (b *Bucket) List(ctx context.Context) ([]string, error) {
+	return b.RetrieveObjectsInBucket(ctx)
+}
+
+func (b *Bucket) Exists(ctx context.Context, name string) (bool, error) {
+	return b.ObjectExists(ctx, name)
+}
+
+func (b *Bucket) Upload(ctx context.Context, localPath string, progress TransferProgress) error {
+	crc, err := fileCRC32C(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to read file: %v", err)
+	}
+	return b.UploadFileToGCS(ctx, localPath, crc, progress)
+}
+
+func (b *Bucket) UploadCRC32C(ctx context.Context, localPath string, crc32c uint32, progress TransferProgress) error {
+	return b.UploadFileToGCS(ctx, localPath, crc32c, progress)
+}
+
+func (b *Bucket) Download(ctx context.Context, name string, localPath string, progress TransferProgress) error {
+	return b.DownloadDataFromBucket(ctx, name, localPath, progress)
+}
+
+func (b *Bucket) Open(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error) {
+	client, err := b.storageClient()
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := client.Bucket(b.BucketName).Object(name).NewRangeReader(ctx, offset, length)
+	if err != nil {
+		if err == storage.ErrObjectNotExist {
+			return nil, ErrObjectNotExist
+		}
+		return nil, fmt.Errorf("failed to create object reader: %v", err)
+	}
+	return reader, nil
+}
+
 // ////////////////////////////////////////////////////////////////////////////////////////////////////////////
-// uploadFile uploads an object.
-func (b *Bucket) UploadFileToGCS(filePath string) error {
-	bucketName := b.Name
+// uploadFile uploads an object, GCS rejects the upload if the content does not match crc (CRC32C).
+// Cancelling ctx aborts the upload.
+func (b *Bucket) UploadFileToGCS(ctx context.Context, filePath string, crc uint32, progress TransferProgress) error {
+	bucketName := b.BucketName
 	objectName := filepath.Base(filePath)
 
-	// Create a new context
-	ctx := context.Background()
-
-	// Create a client
-	client, err := storage.NewClient(ctx)
+	client, err := b.storageClient()
 	if err != nil {
-		return fmt.Errorf("failed to create client: %v", err)
+		return err
 	}
-	defer client.Close()
 
 	// Open the file
 	file, err := os.Open(filePath)
@@ -49,12 +154,18 @@ func (b *Bucket) UploadFileToGCS(filePath string) error {
 	}
 	defer file.Close()
 
-	// Create a writer to the bucket and object
-	wc := client.Bucket(bucketName).Object(objectName).NewWriter(ctx)
-	defer wc.Close()
+	// Create a writer to the bucket and object, the object is only created once the writer is closed
+	writeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	wc := client.Bucket(bucketName).Object(objectName).NewWriter(writeCtx)
+	wc.ChunkSize = uploadChunkSize
+	wc.CRC32C = crc
+	wc.SendCRC32C = true
 
 	// Copy the file content to GCS
-	if _, err = io.Copy(wc, file); err != nil {
+	if _, err = io.Copy(wc, &progressReader{ctx: ctx, r: file, progress: progress}); err != nil {
+		cancel()
+		wc.Close()
 		return fmt.Errorf("failed to copy file to GCS: %v", err)
 	}
 
@@ -67,21 +178,20 @@ func (b *Bucket) UploadFileToGCS(filePath string) error {
 	return nil
 }
 
-func (b *Bucket) CreateGCSBucket() error {
-	// Setup context and client
-	ctx := context.Background()
-	client, err := storage.NewClient(ctx)
+func (b *Bucket) CreateGCSBucket(ctx context.Context) error {
+	// Setup client
+	client, err := b.storageClient()
 	if err != nil {
-		return fmt.Errorf("failed to create client")
+		return err
 	}
 
 	// Setup client bucket to work from
-	bucket := client.Bucket(b.Name)
+	bucket := client.Bucket(b.BucketName)
 
 	buckets := client.Buckets(ctx, b.projectID)
 	for {
-		if b.Name == "" {
-			return fmt.Errorf("BucketName entered is empty %v.", b.Name)
+		if b.BucketName == "" {
+			return fmt.Errorf("BucketName entered is empty %v.", b.BucketName)
 		}
 		attrs, err := buckets.Next()
 		// Assume bucket not found if at Iterator end and create
@@ -96,52 +206,112 @@ func (b *Bucket) CreateGCSBucket() error {
 				return fmt.Errorf("Failed to create bucket: %v", err)
 			}
 
-			log.Printf("Bucket %v created.\n", b.Name)
+			log.Printf("Bucket %v created.\n", b.BucketName)
 			return nil
 		}
 		if err != nil {
-			return fmt.Errorf("Issues setting up Bucket(%q).Objects(): %v. Double check project id.", attrs.Name, err)
+			return fmt.Errorf("Issues setting up Bucket(%q).Objects(): %v. Double check project id.", b.BucketName, err)
 		}
-		if attrs.Name == b.Name {
-			log.Printf("Bucket %v exists.\n", b.Name)
+		if attrs.Name == b.BucketName {
+			log.Printf("Bucket %v exists.\n", b.BucketName)
 			return nil
 		}
 	}
 }
 
-func (b *Bucket) ObjectExists(objectPath string) (bool, error) {
-	ctx := context.Background()
-	client, err := storage.NewClient(ctx)
+func (b *Bucket) ObjectExists(ctx context.Context, objectPath string) (bool, error) {
+	_, err := b.Stat(ctx, objectPath)
+	if err == ErrObjectNotExist {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (b *Bucket) Stat(ctx context.Context, name string) (ObjectInfo, error) {
+	client, err := b.storageClient()
 	if err != nil {
-		return false, fmt.Errorf("failed to create GCS client: %v", err)
+		return ObjectInfo{}, err
 	}
-	defer client.Close()
 
-	// Check if the object exists
-	_, err = client.Bucket(b.Name).Object(objectPath).Attrs(ctx)
+	attrs, err := client.Bucket(b.BucketName).Object(name).Attrs(ctx)
 	if err != nil {
 		if err == storage.ErrObjectNotExist {
-			return false, nil
+			return ObjectInfo{}, ErrObjectNotExist
 		}
-		return false, err
+		return ObjectInfo{}, err
 	}
-	return true, nil
+
+	return ObjectInfo{
+		Name:         attrs.Name,
+		Size:         attrs.Size,
+		Updated:      attrs.Updated,
+		Generation:   strconv.FormatInt(attrs.Generation, 10),
+		MD5:          hex.EncodeToString(attrs.MD5),
+		CRC32C:       fmt.Sprintf("%08x", attrs.CRC32C),
+		StorageClass: attrs.StorageClass,
+	}, nil
+}
+
+// SetStorageClass rewrites the object in place with the new storage class
+func (b *Bucket) SetStorageClass(ctx context.Context, name, class string) error {
+	client, err := b.storageClient()
+	if err != nil {
+		return err
+	}
+	if class == "" {
+		class = coldStorageClass
+	}
+
+	object := client.Bucket(b.BucketName).Object(name)
+	copier := object.CopierFrom(object)
+	copier.StorageClass = class
+	if _, err := copier.Run(ctx); err != nil {
+		if err == storage.ErrObjectNotExist {
+			return ErrObjectNotExist
+		}
+		return fmt.Errorf("failed to change storage class of %s: %v", name, err)
+	}
+
+	log.Printf("Object %s in bucket %s moved to storage class %s\n", name, b.BucketName, class)
+	return nil
+}
+
+func (b *Bucket) Delete(ctx context.Context, name string) error {
+	client, err := b.storageClient()
+	if err != nil {
+		return err
+	}
+
+	if err := client.Bucket(b.BucketName).Object(name).Delete(ctx); err != nil {
+		if err == storage.ErrObjectNotExist {
+			return ErrObjectNotExist
+		}
+		return fmt.Errorf("failed to delete object %s: %v", name, err)
+	}
+
+	log.Printf("Object %s deleted from bucket %s\n", name, b.BucketName)
+	return nil
 }
 
 func (b *Bucket) RetrieveObjectsInBucket(ctx context.Context) ([]string, error) {
-	log.Println("creating client")
-	client, err := storage.NewClient(ctx)
+	client, err := b.storageClient()
 	if err != nil {
 		return []string{}, err
 	}
-	defer client.Close()
 
 	objects := []string{}
 
 	log.Println("checking if bucket exists")
-	if b.BucketExists(ctx, client) {
+	exists, err := b.BucketExists(ctx, client)
+	if err != nil {
+		return []string{}, err
+	}
+	if exists {
 
-		bucketName := b.Name
+		bucketName := b.BucketName
 		bucket := client.Bucket(bucketName)
 		query := &storage.Query{}
 
@@ -152,9 +322,7 @@ func (b *Bucket) RetrieveObjectsInBucket(ctx context.Context) ([]string, error)
 				break
 			}
 			if err != nil {
-				log.Fatalf("error listing objects %v\n", err)
-				//return nil error to mitigate crash if bucket does not exist
-				return []string{}, nil
+				return []string{}, fmt.Errorf("error listing objects: %v", err)
 			}
 			objects = append(objects, objAttrs.Name)
 		}
@@ -163,21 +331,17 @@ func (b *Bucket) RetrieveObjectsInBucket(ctx context.Context) ([]string, error)
 	return objects, nil
 }
 
-func (b *Bucket) DownloadDataFromBucket(ctx context.Context, objectName string) error {
-	client, err := storage.NewClient(ctx)
+func (b *Bucket) DownloadDataFromBucket(ctx context.Context, objectName string, localPath string, progress TransferProgress) error {
+	client, err := b.storageClient()
 	if err != nil {
-		log.Fatalln(err)
 		return err
 	}
-	defer client.Close()
 
-	bucketName := b.Name
-	localBackupsPath := "backups"
+	bucketName := b.BucketName
 
-	file, err := os.Create(fmt.Sprintf("backups/%s", objectName))
+	file, err := os.Create(localPath)
 	if err != nil {
-		log.Fatalln("failed to create file", err)
-		return err
+		return fmt.Errorf("failed to create file: %v", err)
 	}
 	defer file.Close()
 
@@ -186,32 +350,33 @@ func (b *Bucket) DownloadDataFromBucket(ctx context.Context, objectName string)
 
 	reader, err := object.NewReader(ctx)
 	if err != nil {
-		log.Fatalln("failed to create object reader", err)
-		return err
+		return fmt.Errorf("failed to create object reader: %v", err)
 	}
 	defer reader.Close()
 
-	if _, err := io.Copy(file, reader); err != nil {
-		log.Fatalf("Failed to copy object content to file: %v\n", err)
-		return err
+	if _, err := io.Copy(file, &progressReader{ctx: ctx, r: reader, progress: progress}); err != nil {
+		return fmt.Errorf("failed to copy object content to file: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %v", localPath, err)
 	}
 
-	fmt.Printf("Object %s downloaded to %s\n", objectName, localBackupsPath)
+	fmt.Printf("Object %s downloaded to %s\n", objectName, localPath)
 
 	return nil
 }
 
-// bucketExists checks if a bucket exists.
-func (b *Bucket) BucketExists(ctx context.Context, client *storage.Client) bool {
-	bucketName := b.Name
+// BucketExists checks if a bucket exists. Other errors are returned, so a failed lookup is not
+// mistaken for an empty bucket.
+func (b *Bucket) BucketExists(ctx context.Context, client *storage.Client) (bool, error) {
+	bucketName := b.BucketName
 	bucket := client.Bucket(bucketName)
 	_, err := bucket.Attrs(ctx)
 	if err != nil {
 		if storage.ErrBucketNotExist == err {
-			return false
+			return false, nil
 		}
-		log.Printf("Error checking bucket existence: %v", err)
-		return false
+		return false, fmt.Errorf("failed to look up bucket %s: %v", bucketName, err)
 	}
-	return true
+	return true, nil
 }
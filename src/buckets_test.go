@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsouza/fake-gcs-server/fakestorage"
+)
+
+// newTestBucket returns a store for an existing bucket on an in-process fake GCS server
+func newTestBucket(t *testing.T) (*Bucket, *fakestorage.Server) {
+	t.Helper()
+	server, err := fakestorage.NewServerWithOptions(fakestorage.Options{NoListener: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Stop)
+
+	b, err := NewBucketWithClient("backups", "project", server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+	if err := b.Prepare(context.Background()); err != nil {
+		t.Fatalf("Prepare failed: %v", err)
+	}
+	return b, server
+}
+
+// putTestObject stores an object directly on the fake server, as another machine would
+func putTestObject(t *testing.T, server *fakestorage.Server, name, body string) {
+	t.Helper()
+	server.CreateObject(fakestorage.Object{
+		ObjectAttrs: fakestorage.ObjectAttrs{BucketName: "backups", Name: name},
+		Content:     []byte(body),
+	})
+}
+
+func TestBucket(t *testing.T) {
+	ctx := context.Background()
+	b, _ := newTestBucket(t)
+	if err := b.Prepare(ctx); err != nil {
+		t.Fatalf("Prepare of an existing bucket failed: %v", err)
+	}
+
+	dir := t.TempDir()
+	name := "world_20240101_000000.zip"
+	body := "backup content"
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the transfer manager compares the checksums of the stored object with the file
+	transfers := NewTransferManager(b)
+	transfers.Backoff = time.Millisecond
+	if err := transfers.Upload(ctx, filepath.Join(dir, name), nil); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	names, err := b.List(ctx)
+	if err != nil || len(names) != 1 || names[0] != name {
+		t.Fatalf("List = %v, %v", names, err)
+	}
+	if ok, err := b.Exists(ctx, name); !ok || err != nil {
+		t.Errorf("Exists = %v, %v", ok, err)
+	}
+	if ok, err := b.Exists(ctx, "missing_20240101_000000.zip"); ok || err != nil {
+		t.Errorf("Exists of a missing object = %v, %v", ok, err)
+	}
+	info, err := b.Stat(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != int64(len(body)) || info.Generation == "" {
+		t.Errorf("Stat = %+v", info)
+	}
+
+	r, err := b.Open(ctx, name, 7, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part, err := io.ReadAll(r)
+	r.Close()
+	if err != nil || string(part) != "cont" {
+		t.Errorf("Open(7, 4) read %q, %v", part, err)
+	}
+
+	downloaded := filepath.Join(dir, "downloaded.zip")
+	if err := transfers.Download(ctx, name, downloaded, nil); err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	if got, _ := os.ReadFile(downloaded); string(got) != body {
+		t.Errorf("downloaded %q, want %q", got, body)
+	}
+
+	if err := b.SetStorageClass(ctx, name, ""); err != nil {
+		t.Fatalf("SetStorageClass failed: %v", err)
+	}
+	// the fake server keeps every object in STANDARD, the rewrite shows as a new generation
+	if rewritten, err := b.Stat(ctx, name); err != nil || rewritten.Generation == info.Generation {
+		t.Errorf("generation after SetStorageClass = %q, %v, was %q", rewritten.Generation, err, info.Generation)
+	}
+
+	if err := b.Delete(ctx, name); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := b.Delete(ctx, name); err != ErrObjectNotExist {
+		t.Errorf("second Delete = %v, want ErrObjectNotExist", err)
+	}
+	if _, err := b.Stat(ctx, name); err != ErrObjectNotExist {
+		t.Errorf("Stat after Delete = %v, want ErrObjectNotExist", err)
+	}
+	if _, err := b.Open(ctx, name, 0, -1); err != ErrObjectNotExist {
+		t.Errorf("Open after Delete = %v, want ErrObjectNotExist", err)
+	}
+
+	if err := b.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+	if err := b.Close(); err != nil {
+		t.Errorf("second Close failed: %v", err)
+	}
+}
+
+// TestBucketChunkedUpload uploads a file larger than one chunk of a resumable upload
+func TestBucketChunkedUpload(t *testing.T) {
+	ctx := context.Background()
+	b, _ := newTestBucket(t)
+
+	path := filepath.Join(t.TempDir(), "world_20240101_000000.tar.gz")
+	body := make([]byte, uploadChunkSize+1024)
+	for i := range body {
+		body[i] = byte(i % 251)
+	}
+	if err := os.WriteFile(path, body, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var sent int64
+	transfers := NewTransferManager(b)
+	transfers.Backoff = time.Millisecond
+	if err := transfers.Upload(ctx, path, func(n int64) { sent += n }); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if sent != int64(len(body)) {
+		t.Errorf("progress reported %d bytes, want %d", sent, len(body))
+	}
+	if info, err := b.Stat(ctx, filepath.Base(path)); err != nil || info.Size != int64(len(body)) {
+		t.Errorf("Stat = %+v, %v", info, err)
+	}
+}
+
+// crcRecordingBucket records the CRC32C uploads are checked against
+type crcRecordingBucket struct {
+	*Bucket
+	crc32c []uint32
+}
+
+func (b *crcRecordingBucket) UploadCRC32C(ctx context.Context, localPath string, crc32c uint32, progress TransferProgress) error {
+	b.crc32c = append(b.crc32c, crc32c)
+	return b.Bucket.UploadCRC32C(ctx, localPath, crc32c, progress)
+}
+
+// TestTransferPassesCRC32C hands the CRC32C computed with the other checksums to the bucket
+func TestTransferPassesCRC32C(t *testing.T) {
+	ctx := context.Background()
+	bucket, _ := newTestBucket(t)
+	b := &crcRecordingBucket{Bucket: bucket}
+
+	path := filepath.Join(t.TempDir(), "world_20240101_000000.zip")
+	if err := os.WriteFile(path, []byte("backup"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	crc, err := fileCRC32C(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewTransferManager(b).Upload(ctx, path, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.crc32c) != 1 || b.crc32c[0] != crc {
+		t.Errorf("uploads checked against %v, want %08x", b.crc32c, crc)
+	}
+	info, err := b.Stat(ctx, filepath.Base(path))
+	if err != nil || info.CRC32C != fmt.Sprintf("%08x", crc) {
+		t.Errorf("Stat = %+v, %v", info, err)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// CloudBackup is a backup in the backup store, Local is set if there is a local copy as well
+type CloudBackup struct {
+	ObjectInfo
+	Local bool `json:"local"`
+}
+
+// CloudBackups describes every backup in the backup store, objects are looked up in parallel
+func (s *APIServer) CloudBackups(ctx context.Context) ([]CloudBackup, error) {
+	if s.store == nil {
+		return nil, ErrNoStore
+	}
+	names, err := s.store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	backups := make([]CloudBackup, len(names))
+	errs := make([]error, len(names))
+	s.Transfers.Each(ctx, len(names), func(i int) {
+		info, err := s.store.Stat(ctx, names[i])
+		if err != nil {
+			errs[i] = err
+			return
+		}
+		backups[i].ObjectInfo = info
+		if path, err := backupPath(names[i]); err == nil {
+			backups[i].Local, _ = exists(path)
+		}
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	found := backups[:0]
+	for i, backup := range backups {
+		if errs[i] == ErrObjectNotExist {
+			continue // deleted while listing
+		}
+		if errs[i] != nil {
+			return nil, fmt.Errorf("failed to look up %s: %v", names[i], errs[i])
+		}
+		found = append(found, backup)
+	}
+	return found, nil
+}
+
+// PullCloudBackup downloads a backup from the backup store next to the local backups and
+// records it as synchronized, an existing local copy is replaced
+func (s *APIServer) PullCloudBackup(ctx context.Context, name string, progress func(JobProgress)) error {
+	path, err := backupPath(name)
+	if err != nil {
+		return err
+	}
+	if s.store == nil {
+		return ErrNoStore
+	}
+
+	return s.Syncer.Update(func(state map[string]SyncRecord) error {
+		info, err := s.store.Stat(ctx, name)
+		if err != nil {
+			return err
+		}
+
+		p := JobProgress{Item: name, ItemsTotal: 1, BytesTotal: info.Size}
+		report := func() {
+			if progress != nil {
+				progress(p)
+			}
+		}
+		report()
+
+		log.Printf("downloading backup %s from %s\n", name, s.store.Name())
+		err = s.Transfers.Download(ctx, name, path, func(n int64) {
+			p.BytesDone += n
+			report()
+		})
+		if err != nil {
+			return err
+		}
+		p.ItemsDone++
+		report()
+
+		// the manifest of a replaced copy describes another archive
+		if err := os.Remove(manifestPath(name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		go describeBackups()
+
+		record, err := s.Syncer.record(ctx, name, "")
+		if err != nil {
+			return err
+		}
+		state[name] = record
+		return nil
+	})
+}
+
+// LoadCloudBackup restores a backup from the backup store, it is fetched first unless there
+// is a local copy already
+func (s *APIServer) LoadCloudBackup(ctx context.Context, name string, paths []string, progress ProgressFunc, transfer func(JobProgress)) error {
+	path, err := backupPath(name)
+	if err != nil {
+		return err
+	}
+	if s.store == nil {
+		return ErrNoStore
+	}
+	if ok, _ := exists(path); !ok {
+		progress.report("downloading backup from " + s.store.Name())
+		if err := s.PullCloudBackup(ctx, name, transfer); err != nil {
+			return err
+		}
+	}
+	return s.LoadBackupFromDisk(name, paths, progress)
+}
+
+// RemoveCloudBackup deletes a backup from the backup store. A local copy is kept and marked as
+// local only, so the next sync neither uploads it again nor deletes it.
+func (s *APIServer) RemoveCloudBackup(ctx context.Context, name string) error {
+	path, err := backupPath(name)
+	if err != nil {
+		return err
+	}
+	if s.store == nil {
+		return ErrNoStore
+	}
+
+	return s.Syncer.Update(func(state map[string]SyncRecord) error {
+		if err := s.store.Delete(ctx, name); err != nil {
+			return err
+		}
+		// keep the local copy from being uploaded again by the next sync
+		if ok, _ := exists(path); ok {
+			state[name] = SyncRecord{Name: name, Synced: time.Now(), LocalOnly: true}
+		} else {
+			delete(state, name)
+		}
+		return nil
+	})
+}
+
+// ChangeCloudStorageClass moves a backup in the backup store to another storage class, an empty
+// class selects the store's cold class. The sync record follows the rewritten object, so the
+// backup is not downloaded again.
+func (s *APIServer) ChangeCloudStorageClass(ctx context.Context, name, class string) (ObjectInfo, error) {
+	if _, err := backupPath(name); err != nil {
+		return ObjectInfo{}, err
+	}
+	store, ok := s.store.(StorageClassStore)
+	if !ok {
+		return ObjectInfo{}, ErrNotSupported
+	}
+
+	var info ObjectInfo
+	err := s.Syncer.Update(func(state map[string]SyncRecord) error {
+		before, err := s.store.Stat(ctx, name)
+		if err != nil {
+			return err
+		}
+		if err := store.SetStorageClass(ctx, name, class); err != nil {
+			return err
+		}
+		if info, err = s.store.Stat(ctx, name); err != nil {
+			return err
+		}
+
+		if record, ok := state[name]; ok && record.Generation == before.Generation {
+			record.Generation = info.Generation
+			state[name] = record
+		}
+		return nil
+	})
+	return info, err
+}
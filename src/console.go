@@ -0,0 +1,65 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const maxConsoleHistory = 200
+
+// ConsoleEntry is a single command issued through the web console
+type ConsoleEntry struct {
+	Command  string    `json:"command"`
+	Response string    `json:"response"`
+	Error    string    `json:"error,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// ConsoleHistory keeps the commands issued by each login session
+type ConsoleHistory struct {
+	mu       sync.Mutex
+	sessions map[string][]ConsoleEntry
+}
+
+func NewConsoleHistory() *ConsoleHistory {
+	return &ConsoleHistory{
+		sessions: map[string][]ConsoleEntry{},
+	}
+}
+
+func (h *ConsoleHistory) Add(session string, entry ConsoleEntry) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	entries := append(h.sessions[session], entry)
+	if len(entries) > maxConsoleHistory {
+		entries = entries[len(entries)-maxConsoleHistory:]
+	}
+	h.sessions[session] = entries
+}
+
+func (h *ConsoleHistory) Get(session string) []ConsoleEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	entries := make([]ConsoleEntry, len(h.sessions[session]))
+	copy(entries, h.sessions[session])
+	return entries
+}
+
+// RunConsoleCommand sends cmd to the server over rcon and records it in the session's history
+func (s *APIServer) RunConsoleCommand(rcon *RCONClient, session string, cmd string) ConsoleEntry {
+	entry := ConsoleEntry{
+		Command: cmd,
+		Time:    time.Now(),
+	}
+
+	resp, err := rcon.Command(cmd)
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	entry.Response = resp
+
+	s.Console.Add(session, entry)
+	return entry
+}
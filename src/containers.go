@@ -3,11 +3,16 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
@@ -17,6 +22,10 @@ import (
 	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
+// forceStopGrace is how long docker waits after SIGTERM before it kills a server that did not
+// stop over RCON
+const forceStopGrace = 60 * time.Second
+
 type ContainerRunner struct {
 	Image         string                      // image for a container
 	BuildMode     bool                        // build mode (if set to true -> container builds from Dockerfile)
@@ -30,6 +39,12 @@ type ContainerRunner struct {
 	Platform      v1.Platform                 // platform
 	PullOpts      types.ImagePullOptions      // pull options (applies for BuildMode: false)
 	StartOpts     types.ContainerStartOptions // start options
+	RCONAddr      string                      // address of the server's remote console (host:port)
+	RCONPassword  string                      // remote console password
+	StopTimeout   time.Duration               // how long to wait for a graceful shutdown before force-stopping
+
+	clientMu    sync.Mutex
+	clientReady bool
 }
 
 func NewContainerRunner(img string,
@@ -56,14 +71,22 @@ func NewContainerRunner(img string,
 	}
 }
 
+// InitializeClient creates the docker client on first use, later calls reuse it. The client is
+// shared by all jobs and status requests, it is safe for concurrent use.
 func (r *ContainerRunner) InitializeClient() error {
+	r.clientMu.Lock()
+	defer r.clientMu.Unlock()
+
+	if r.clientReady {
+		return nil
+	}
 	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 	if err != nil {
 		log.Printf("Pull error")
 		return err
 	}
 	r.Client = cli
-	defer r.Client.Close()
+	r.clientReady = true
 
 	return nil
 }
@@ -130,89 +153,257 @@ func (r *ContainerRunner) StartContainer(resp container.CreateResponse) error {
 	return r.Client.ContainerStart(r.Context, resp.ID, r.StartOpts)
 }
 
-func (r *ContainerRunner) Containerize() {
+func (r *ContainerRunner) Containerize(progress ProgressFunc) error {
 
 	if err := r.InitializeClient(); err != nil {
 		log.Printf("init client error")
 		log.Printf("Error initializing client %s\n", err)
-		return
+		return fmt.Errorf("failed to initialize docker client: %v", err)
 	}
 	log.Printf("initialized client")
 
+	progress.report("pulling image")
 	out, err := r.PullDockerImage()
 	if err != nil {
 		log.Println(out)
 		log.Printf("Error pulling image %s\n", err)
-		return
+		return fmt.Errorf("failed to pull image %s: %v", r.Image, err)
 	}
 
+	progress.report("creating network")
 	netresp, err := r.CreateNetwork()
 	if err != nil {
 		log.Printf("Error creating network %s\n", err)
-		return
+		return fmt.Errorf("failed to create network %s: %v", r.NetworkName, err)
 	}
 	fmt.Printf("Created network with name %v and ID: %v\n", r.NetworkName, netresp.ID)
 
+	progress.report("creating container")
 	resp, err := r.CreateContainer()
 	if err != nil {
 		log.Printf("Error creating container %s\n", err)
-		return
+		return fmt.Errorf("failed to create container %s: %v", r.ContainerName, err)
 	}
 
+	progress.report("starting container")
 	if err := r.StartContainer(resp); err != nil {
 		log.Printf("Error starting container %s\n", err)
-		return
+		return fmt.Errorf("failed to start container %s: %v", r.ContainerName, err)
 	}
 	log.Printf("ID of created container: %s\n", resp.ID)
 
+	return nil
+}
+
+// RCON opens an authenticated remote console connection to the running server
+func (r *ContainerRunner) RCON() (*RCONClient, error) {
+	if r.RCONAddr == "" || r.RCONPassword == "" {
+		return nil, fmt.Errorf("rcon is not configured")
+	}
+	return DialRCON(r.RCONAddr, r.RCONPassword, rconTimeout)
 }
 
-func (r *ContainerRunner) StopContainer() {
+// shutdownServer asks the Minecraft server to save the world and exit. It reports whether
+// "stop" was sent, the server may be shutting down even if its reply was lost.
+func (r *ContainerRunner) shutdownServer() (bool, error) {
+	rcon, err := r.RCON()
+	if err != nil {
+		return false, err
+	}
+	defer rcon.Close()
+
+	for _, cmd := range []string{"say Server is shutting down, saving world...", "save-all", "stop"} {
+		resp, err := rcon.Command(cmd)
+		if err != nil {
+			return cmd == "stop" && errors.Is(err, ErrRCONNoReply), fmt.Errorf("rcon command %q failed: %v", cmd, err)
+		}
+		log.Printf("rcon %q: %s\n", cmd, resp)
+	}
+
+	return true, nil
+}
+
+// waitForExit blocks until the container stops (or is removed when AutoRemove is set) or the timeout passes
+func (r *ContainerRunner) waitForExit(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(r.Context, timeout)
+	defer cancel()
+
+	condition := container.WaitConditionNotRunning
+	if r.HostConf.AutoRemove {
+		condition = container.WaitConditionRemoved
+	}
+
+	respCh, errCh := r.Client.ContainerWait(ctx, r.ContainerName, condition)
+	select {
+	case <-respCh:
+		return nil
+	case err := <-errCh:
+		if client.IsErrNotFound(err) {
+			return nil
+		}
+		return err
+	}
+}
+
+func (r *ContainerRunner) StopContainer(progress ProgressFunc) error {
 	if err := r.InitializeClient(); err != nil {
 		log.Printf("Error initializing client %s\n", err)
+		return fmt.Errorf("failed to initialize docker client: %v", err)
 	}
 
-	noWaitTimeout := 0
+	// the server gets SIGTERM, which also saves the world, before it is killed
+	forceStopTimeout := int(forceStopGrace.Seconds())
 	containerFilters := filters.NewArgs()
 	containerFilters.Add("name", r.ContainerName)
 
 	networkFilters := filters.NewArgs()
 	networkFilters.Add("name", r.NetworkName)
 
+	progress.report("looking up container")
 	containers, err := r.Client.ContainerList(r.Context, types.ContainerListOptions{Filters: containerFilters})
 	if err != nil {
 		log.Printf("Error listing containers %s\n", err)
-		return
+		return fmt.Errorf("failed to list containers: %v", err)
 	}
 	networks, err := r.Client.NetworkList(r.Context, types.NetworkListOptions{Filters: networkFilters})
 
 	if err != nil {
 		log.Printf("Error listing networks %s\n", err)
-		return
+		return fmt.Errorf("failed to list networks: %v", err)
 	}
 
 	if len(containers) == 0 && len(networks) == 0 {
 		log.Printf("Container does not exist\n")
-		return
+		return nil
 	}
 
 	if len(containers) == 1 {
 		log.Printf("container ID found: %s", containers[0].ID)
-		if err := r.Client.ContainerStop(r.Context, r.ContainerName, container.StopOptions{Timeout: &noWaitTimeout}); err != nil {
-			log.Printf("Error stopping container %s\n", err)
-			return
+
+		graceful := false
+		progress.report("saving world")
+		stopSent, err := r.shutdownServer()
+		if err != nil {
+			log.Printf("Graceful shutdown via rcon failed: %s\n", err)
+		}
+		// once "stop" was sent the server is saving, stopping the container now would interrupt it
+		if stopSent {
+			progress.report("waiting for server to exit")
+			if err := r.waitForExit(r.StopTimeout); err != nil {
+				log.Printf("Server did not exit within %v: %s\n", r.StopTimeout, err)
+			} else {
+				graceful = true
+				log.Printf("Server %s exited gracefully\n", r.ContainerName)
+			}
 		}
-		log.Printf("Success stopping container %s\n", r.ContainerName)
 
+		if !graceful {
+			progress.report("stopping container")
+			if err := r.Client.ContainerStop(r.Context, r.ContainerName, container.StopOptions{Timeout: &forceStopTimeout}); err != nil && !client.IsErrNotFound(err) {
+				log.Printf("Error stopping container %s\n", err)
+				return fmt.Errorf("failed to stop container %s: %v", r.ContainerName, err)
+			}
+			if err := r.waitForExit(r.StopTimeout); err != nil {
+				log.Printf("Error waiting for container removal %s\n", err)
+			}
+			log.Printf("Success stopping container %s\n", r.ContainerName)
+		}
 	}
 
 	if len(networks) == 1 {
 		log.Printf("network ID found: %s", networks[0].ID)
+		progress.report("removing network")
 		if err := r.Client.NetworkRemove(r.Context, r.NetworkName); err != nil {
 			log.Printf("Error removing network %s\n", err)
-			return
+			return fmt.Errorf("failed to remove network %s: %v", r.NetworkName, err)
 		}
 		log.Printf("Success removing network %s\n", r.NetworkName)
 	}
 
+	return nil
+}
+
+// ContainerStatus describes the current state of the server container
+type ContainerStatus struct {
+	Name      string            `json:"name"`
+	Exists    bool              `json:"exists"`
+	State     string            `json:"state"`  // created, running, paused, restarting, removing, exited, dead or "not found"
+	Health    string            `json:"health"` // starting, healthy, unhealthy (empty if no healthcheck)
+	Running   bool              `json:"running"`
+	StartedAt time.Time         `json:"startedAt"`
+	Uptime    string            `json:"uptime"`
+	Image     string            `json:"image"`
+	ImageID   string            `json:"imageId"`
+	Digest    string            `json:"digest"`
+	Networks  []string          `json:"networks"`
+	Ports     map[string]string `json:"ports"`
+	ExitCode  int               `json:"exitCode"`
+	Error     string            `json:"error,omitempty"`
+}
+
+// Status inspects the server container and reports its state
+func (r *ContainerRunner) Status() (ContainerStatus, error) {
+	status := ContainerStatus{
+		Name:  r.ContainerName,
+		State: "not found",
+		Image: r.Image,
+		Ports: map[string]string{},
+	}
+
+	if err := r.InitializeClient(); err != nil {
+		return status, err
+	}
+
+	info, err := r.Client.ContainerInspect(r.Context, r.ContainerName)
+	if err != nil {
+		if client.IsErrNotFound(err) {
+			return status, nil
+		}
+		return status, err
+	}
+
+	status.Exists = true
+	status.ImageID = info.Image
+	if info.Config != nil {
+		status.Image = info.Config.Image
+	}
+
+	if info.State != nil {
+		status.State = info.State.Status
+		status.Running = info.State.Running
+		status.ExitCode = info.State.ExitCode
+		status.Error = info.State.Error
+		if info.State.Health != nil {
+			status.Health = info.State.Health.Status
+		}
+		if startedAt, err := time.Parse(time.RFC3339Nano, info.State.StartedAt); err == nil {
+			status.StartedAt = startedAt
+			if status.Running {
+				status.Uptime = time.Since(startedAt).Round(time.Second).String()
+			}
+		}
+	}
+
+	if info.NetworkSettings != nil {
+		for name := range info.NetworkSettings.Networks {
+			status.Networks = append(status.Networks, name)
+		}
+		sort.Strings(status.Networks)
+
+		for port, bindings := range info.NetworkSettings.Ports {
+			var hostPorts []string
+			for _, b := range bindings {
+				hostPorts = append(hostPorts, fmt.Sprintf("%s:%s", b.HostIP, b.HostPort))
+			}
+			status.Ports[string(port)] = strings.Join(hostPorts, ", ")
+		}
+	}
+
+	// repo digest identifies the exact image that was pulled
+	imageInfo, _, err := r.Client.ImageInspectWithRaw(r.Context, info.Image)
+	if err == nil && len(imageInfo.RepoDigests) > 0 {
+		status.Digest = imageInfo.RepoDigests[0]
+	}
+
+	return status, nil
 }
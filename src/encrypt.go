@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Encrypted backups start with a header (magic, key ID, nonce prefix) followed by the archive
+// split into segments that are sealed with AES-256-GCM separately, so a zip can still be read
+// at random offsets. The nonce of a segment is the prefix, the segment number and a flag marking
+// the last segment, which makes reordered, dropped or truncated segments fail to decrypt.
+const (
+	encryptionMagic       = "mcbkenc1"
+	encryptionSegmentSize = 64 << 10
+	encryptionNoncePrefix = 7
+)
+
+var ErrUnknownKey = errors.New("backup is encrypted with an unknown key")
+
+// backupKeys encrypts new archives and decrypts existing ones, nil disables encryption
+var backupKeys *Keyring
+
+// Keyring holds the key new backups are encrypted with and older keys that are still accepted
+// for reading, so the key can be rotated without re-encrypting existing backups
+type Keyring struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// NewKeyring creates a keyring from base64 encoded 32 byte keys. New backups are encrypted with
+// current, if it is empty backups are only decrypted.
+func NewKeyring(current string, old []string) (*Keyring, error) {
+	k := &Keyring{keys: map[string]cipher.AEAD{}}
+	for i, encoded := range append([]string{current}, old...) {
+		encoded = strings.TrimSpace(encoded)
+		if encoded == "" {
+			continue
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid backup key: %v", err)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("invalid backup key: need 32 bytes, got %d", len(key))
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+
+		id := keyID(key)
+		k.keys[id] = aead
+		if i == 0 {
+			k.current = id
+		}
+	}
+	if len(k.keys) == 0 {
+		return nil, fmt.Errorf("no backup encryption key set")
+	}
+	return k, nil
+}
+
+// keyID identifies a key without revealing it
+func keyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// CurrentKeyID returns the ID of the key new backups are encrypted with, empty if they are not encrypted
+func (k *Keyring) CurrentKeyID() string {
+	if k == nil {
+		return ""
+	}
+	return k.current
+}
+
+// encryptingWriter seals everything written to it into segments, Close writes the last segment
+type encryptingWriter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	header []byte
+	prefix []byte
+	buf    []byte
+	n      uint32
+}
+
+// newEncryptingWriter writes the header to w and returns a writer encrypting with the current key
+func (k *Keyring) newEncryptingWriter(w io.Writer) (io.WriteCloser, error) {
+	prefix := make([]byte, encryptionNoncePrefix)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+
+	header := encryptionHeader(k.current, prefix)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &encryptingWriter{
+		w:      w,
+		aead:   k.keys[k.current],
+		header: header,
+		prefix: prefix,
+		buf:    make([]byte, 0, encryptionSegmentSize),
+	}, nil
+}
+
+func (e *encryptingWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		// a full segment is only sealed once more data follows, the last one is sealed by Close
+		if len(e.buf) == encryptionSegmentSize {
+			if err := e.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(e.buf[len(e.buf):encryptionSegmentSize], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (e *encryptingWriter) seal(last bool) error {
+	nonce := segmentNonce(e.prefix, e.n, last)
+	if _, err := e.w.Write(e.aead.Seal(nil, nonce, e.buf, e.header)); err != nil {
+		return err
+	}
+	e.n++
+	e.buf = e.buf[:0]
+	return nil
+}
+
+func (e *encryptingWriter) Close() error {
+	return e.seal(true)
+}
+
+func encryptionHeader(id string, prefix []byte) []byte {
+	header := []byte(encryptionMagic)
+	header = append(header, byte(len(id)))
+	header = append(header, id...)
+	return append(header, prefix...)
+}
+
+func segmentNonce(prefix []byte, n uint32, last bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[encryptionNoncePrefix:], n)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// readEncryptionHeader returns the key ID and header of an encrypted file, ok is false for plain files
+func readEncryptionHeader(r io.ReaderAt) (id string, header []byte, ok bool, err error) {
+	start := make([]byte, len(encryptionMagic)+1)
+	if _, err := r.ReadAt(start, 0); err != nil {
+		if err == io.EOF {
+			return "", nil, false, nil
+		}
+		return "", nil, false, err
+	}
+	if !bytes.Equal(start[:len(encryptionMagic)], []byte(encryptionMagic)) {
+		return "", nil, false, nil
+	}
+
+	header = make([]byte, len(start)+int(start[len(encryptionMagic)])+encryptionNoncePrefix)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return "", nil, true, fmt.Errorf("truncated encryption header: %v", err)
+	}
+	id = string(header[len(start) : len(header)-encryptionNoncePrefix])
+	return id, header, true, nil
+}
+
+// decryptingReaderAt reads the plaintext of an encrypted file, the last decrypted segment is cached
+type decryptingReaderAt struct {
+	r        io.ReaderAt
+	aead     cipher.AEAD
+	header   []byte
+	prefix   []byte
+	size     int64 // plaintext size
+	body     int64 // size of all sealed segments
+	segments int64
+
+	mu     sync.Mutex
+	cached int64
+	plain  []byte
+}
+
+func (k *Keyring) newDecryptingReaderAt(r io.ReaderAt, fileSize int64, id string, header []byte) (*decryptingReaderAt, error) {
+	var aead cipher.AEAD
+	if k != nil {
+		aead = k.keys[id]
+	}
+	if aead == nil {
+		return nil, fmt.Errorf("%w %s", ErrUnknownKey, id)
+	}
+
+	sealed := int64(encryptionSegmentSize + aead.Overhead())
+	body := fileSize - int64(len(header))
+	segments := (body + sealed - 1) / sealed
+	if segments == 0 || body-(segments-1)*sealed < int64(aead.Overhead()) {
+		return nil, fmt.Errorf("encrypted backup is truncated")
+	}
+
+	return &decryptingReaderAt{
+		r:        r,
+		aead:     aead,
+		header:   header,
+		prefix:   header[len(header)-encryptionNoncePrefix:],
+		size:     body - segments*int64(aead.Overhead()),
+		body:     body,
+		segments: segments,
+		cached:   -1,
+	}, nil
+}
+
+func (d *decryptingReaderAt) segment(n int64) ([]byte, error) {
+	if n == d.cached {
+		return d.plain, nil
+	}
+
+	sealed := int64(encryptionSegmentSize + d.aead.Overhead())
+	length := sealed
+	if n == d.segments-1 {
+		length = d.body - n*sealed
+	}
+	buf := make([]byte, length)
+	if _, err := d.r.ReadAt(buf, int64(len(d.header))+n*sealed); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	plain, err := d.aead.Open(buf[:0], segmentNonce(d.prefix, uint32(n), n == d.segments-1), buf, d.header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt segment %d, backup is corrupt or truncated", n)
+	}
+	d.cached = n
+	d.plain = plain
+	return plain, nil
+}
+
+func (d *decryptingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	read := 0
+	for read < len(p) {
+		if off >= d.size {
+			return read, io.EOF
+		}
+		plain, err := d.segment(off / encryptionSegmentSize)
+		if err != nil {
+			return read, err
+		}
+		n := copy(p[read:], plain[off%encryptionSegmentSize:])
+		read += n
+		off += int64(n)
+	}
+	return read, nil
+}
+
+// backupFile gives access to the archive in a backup file, decrypting it if needed
+type backupFile struct {
+	io.ReaderAt
+	Size  int64  // archive size
+	KeyID string // empty for unencrypted backups
+	file  *os.File
+}
+
+func openBackupFile(path string) (*backupFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	id, header, encrypted, err := readEncryptionHeader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if !encrypted {
+		return &backupFile{ReaderAt: file, Size: info.Size(), file: file}, nil
+	}
+
+	reader, err := backupKeys.newDecryptingReaderAt(file, info.Size(), id, header)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &backupFile{ReaderAt: reader, Size: reader.size, KeyID: id, file: file}, nil
+}
+
+// Reader reads the archive from the start
+func (b *backupFile) Reader() io.Reader {
+	return io.NewSectionReader(b, 0, b.Size)
+}
+
+func (b *backupFile) Close() error {
+	return b.file.Close()
+}
+
+// backupKeyID returns the ID of the key a backup is encrypted with, empty if it is not encrypted
+func backupKeyID(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	id, _, _, err := readEncryptionHeader(file)
+	return id, err
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	JobPending   = "pending"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCanceled  = "canceled"
+
+	maxFinishedJobs = 100
+)
+
+// ProgressFunc is called by long running operations whenever they enter a new stage
+type ProgressFunc func(stage string)
+
+func (p ProgressFunc) report(stage string) {
+	if p != nil {
+		p(stage)
+	}
+}
+
+// JobProgress is the detailed progress of a job that works through items (e.g. files) of known size
+type JobProgress struct {
+	Item           string  `json:"item,omitempty"` // what is being worked on
+	ItemsDone      int     `json:"itemsDone"`
+	ItemsTotal     int     `json:"itemsTotal"`
+	BytesDone      int64   `json:"bytesDone"`
+	BytesTotal     int64   `json:"bytesTotal"`
+	BytesPerSecond float64 `json:"bytesPerSecond,omitempty"`
+	ETASeconds     float64 `json:"etaSeconds,omitempty"`
+}
+
+// Job tracks a single asynchronous action (start, stop, ...) and its outcome
+type Job struct {
+	ID       string       `json:"id"`
+	Action   string       `json:"action"`
+	Status   string       `json:"status"`
+	Stage    string       `json:"stage"`
+	Progress *JobProgress `json:"progress,omitempty"`
+	Error    string       `json:"error,omitempty"`
+	Created  time.Time    `json:"created"`
+	Updated  time.Time    `json:"updated"`
+	Finished time.Time    `json:"finished,omitempty"`
+
+	mu      sync.Mutex
+	started time.Time
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// Context is cancelled when the job is cancelled
+func (j *Job) Context() context.Context {
+	return j.ctx
+}
+
+// SetStage records the current progress stage of a job, it satisfies ProgressFunc
+func (j *Job) SetStage(stage string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.Stage = stage
+	j.Updated = time.Now()
+}
+
+// SetProgress records the detailed progress of a job, rate and ETA are derived from the bytes done
+func (j *Job) SetProgress(p JobProgress) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if elapsed := time.Since(j.started).Seconds(); elapsed > 0 && p.BytesDone > 0 {
+		p.BytesPerSecond = float64(p.BytesDone) / elapsed
+		p.ETASeconds = float64(p.BytesTotal-p.BytesDone) / p.BytesPerSecond
+	}
+	j.Progress = &p
+	j.Updated = time.Now()
+}
+
+func (j *Job) finish(err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.Status = JobSucceeded
+	if err != nil {
+		j.Status = JobFailed
+		if errors.Is(err, context.Canceled) && j.ctx.Err() != nil {
+			j.Status = JobCanceled
+		}
+		j.Error = err.Error()
+	}
+	j.Updated = time.Now()
+	j.Finished = j.Updated
+	j.cancel()
+}
+
+func (j *Job) done() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.Status == JobSucceeded || j.Status == JobFailed || j.Status == JobCanceled
+}
+
+// Snapshot returns a copy of the job that is safe to read without locking
+func (j *Job) Snapshot() Job {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var progress *JobProgress
+	if j.Progress != nil {
+		p := *j.Progress
+		progress = &p
+	}
+	return Job{
+		ID:       j.ID,
+		Action:   j.Action,
+		Status:   j.Status,
+		Stage:    j.Stage,
+		Progress: progress,
+		Error:    j.Error,
+		Created:  j.Created,
+		Updated:  j.Updated,
+		Finished: j.Finished,
+	}
+}
+
+type JobManager struct {
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+func NewJobManager() *JobManager {
+	return &JobManager{
+		jobs: map[string]*Job{},
+	}
+}
+
+// Run registers a new job and executes fn in the background
+func (m *JobManager) Run(action string, fn func(job *Job) error) *Job {
+	now := time.Now()
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &Job{
+		ID:      newJobID(),
+		Action:  action,
+		Status:  JobPending,
+		Created: now,
+		Updated: now,
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+
+	m.mu.Lock()
+	m.jobs[job.ID] = job
+	m.prune()
+	m.mu.Unlock()
+
+	go func() {
+		job.mu.Lock()
+		job.Status = JobRunning
+		job.started = time.Now()
+		job.mu.Unlock()
+
+		err := fn(job)
+		job.finish(err)
+	}()
+
+	return job
+}
+
+func (m *JobManager) Get(id string) (*Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	return job, ok
+}
+
+// Cancel asks a running job to stop, the job decides when it is safe to do so
+func (m *JobManager) Cancel(id string) error {
+	job, ok := m.Get(id)
+	if !ok {
+		return fmt.Errorf("job %s not found", id)
+	}
+	if job.done() {
+		return fmt.Errorf("job %s already finished", id)
+	}
+	job.cancel()
+	return nil
+}
+
+// List returns snapshots of all known jobs, newest first
+func (m *JobManager) List() []Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	jobs := make([]Job, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		jobs = append(jobs, job.Snapshot())
+	}
+	sort.Slice(jobs, func(i, k int) bool {
+		return jobs[i].Created.After(jobs[k].Created)
+	})
+	return jobs
+}
+
+// prune drops the oldest finished jobs, caller must hold m.mu
+func (m *JobManager) prune() {
+	var finished []*Job
+	for _, job := range m.jobs {
+		if job.done() {
+			finished = append(finished, job)
+		}
+	}
+	if len(finished) <= maxFinishedJobs {
+		return
+	}
+	sort.Slice(finished, func(i, k int) bool {
+		return finished[i].Created.Before(finished[k].Created)
+	})
+	for _, job := range finished[:len(finished)-maxFinishedJobs] {
+		delete(m.jobs, job.ID)
+	}
+}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// LocalStore keeps backups in a plain directory, e.g. a mounted NAS share
+type LocalStore struct {
+	Dir string
+}
+
+func NewLocalStore(dir string) (*LocalStore, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("BACKUP_STORE_DIR is empty")
+	}
+	return &LocalStore{Dir: dir}, nil
+}
+
+func (l *LocalStore) Name() string {
+	return "file://" + l.Dir
+}
+
+func (l *LocalStore) Prepare(ctx context.Context) error {
+	return os.MkdirAll(l.Dir, 0755)
+}
+
+func (l *LocalStore) path(name string) (string, error) {
+	if name == "" || name != filepath.Base(name) {
+		return "", fmt.Errorf("invalid object name %q", name)
+	}
+	return filepath.Join(l.Dir, name), nil
+}
+
+func (l *LocalStore) List(ctx context.Context) ([]string, error) {
+	files, err := os.ReadDir(l.Dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+
+	objects := []string{}
+	for _, file := range files {
+		if file.Type().IsRegular() {
+			objects = append(objects, file.Name())
+		}
+	}
+	return objects, nil
+}
+
+func (l *LocalStore) Exists(ctx context.Context, name string) (bool, error) {
+	path, err := l.path(name)
+	if err != nil {
+		return false, err
+	}
+	return exists(path)
+}
+
+func (l *LocalStore) Upload(ctx context.Context, localPath string, progress TransferProgress) error {
+	target, err := l.path(filepath.Base(localPath))
+	if err != nil {
+		return err
+	}
+	if err := copyFile(ctx, localPath, target, progress); err != nil {
+		return fmt.Errorf("failed to copy %s to %s: %v", localPath, l.Dir, err)
+	}
+	return nil
+}
+
+func (l *LocalStore) Download(ctx context.Context, name string, localPath string, progress TransferProgress) error {
+	source, err := l.path(name)
+	if err != nil {
+		return err
+	}
+	if err := copyFile(ctx, source, localPath, progress); err != nil {
+		return fmt.Errorf("failed to copy %s from %s: %v", name, l.Dir, err)
+	}
+	return nil
+}
+
+func (l *LocalStore) Open(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error) {
+	path, err := l.path(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrObjectNotExist
+		}
+		return nil, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if length < 0 {
+		return f, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(f, length), f}, nil
+}
+
+func (l *LocalStore) Delete(ctx context.Context, name string) error {
+	path, err := l.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		if os.IsNotExist(err) {
+			return ErrObjectNotExist
+		}
+		return err
+	}
+	return nil
+}
+
+func (l *LocalStore) Stat(ctx context.Context, name string) (ObjectInfo, error) {
+	path, err := l.path(name)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ObjectInfo{}, ErrObjectNotExist
+		}
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{
+		Name:       name,
+		Size:       info.Size(),
+		Updated:    info.ModTime(),
+		Generation: strconv.FormatInt(info.ModTime().UnixNano(), 10),
+	}, nil
+}
+
+func copyFile(ctx context.Context, source, target string, progress TransferProgress) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, &progressReader{ctx: ctx, r: in, progress: progress}); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
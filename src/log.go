@@ -2,30 +2,340 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"os"
+	"regexp"
+	"strings"
+	"time"
 )
 
-func GetMcServerLogs(filename string) ([]string, error) {
-	content, err := ReadLines(filename)
+const (
+	logPollInterval = 500 * time.Millisecond
+	logReadChunk    = 64 * 1024
+
+	DefaultLogLimit = 100
+	MaxLogLimit     = 1000
+)
+
+// matches "[12:34:56] [Server thread/INFO]: message"
+var logLineRegex = regexp.MustCompile(`^\[(\d{2}:\d{2}:\d{2})\] \[([^\]]+)/([A-Z]+)\]: ?(.*)$`)
+
+type LogsTemplateData struct {
+	Entries  []LogEntry
+	Next     int64     // cursor for loading older entries, 0 if the beginning was reached
+	NextTime time.Time // time of the entry at the cursor
+	Offset   int64     // byte offset the live stream should continue from
+}
+
+// GetMcServerLogs returns the newest entries of the server log for the log viewer
+func GetMcServerLogs(filename string) (LogsTemplateData, error) {
+	page, err := ReadLogPage(filename, LogQuery{From: -1, Limit: 500})
 	if err != nil {
 		fmt.Println("log errors")
-		return []string{"error reading file, go back to home page"}, err
+		return LogsTemplateData{Entries: []LogEntry{{Level: "ERROR", Message: "error reading file, go back to home page"}}}, err
 	}
-	return content, err
+	return LogsTemplateData{Entries: page.Entries, Next: page.Next, NextTime: page.NextTime, Offset: page.End}, nil
+}
+
+// LogEntry is a parsed Minecraft log line, continuation lines (e.g. stack traces) are appended to Message
+type LogEntry struct {
+	Offset  int64     `json:"offset"` // byte offset of the entry in the file
+	Time    time.Time `json:"time"`
+	Thread  string    `json:"thread"`
+	Level   string    `json:"level"`
+	Message string    `json:"message"`
 }
 
-func ReadLines(path string) ([]string, error) {
+type LogQuery struct {
+	From int64 // read entries starting before this byte offset, negative means end of file
+	// FromTime is the time of the entry at From as returned with the cursor, dates of older
+	// entries are counted back from it. Without it they are counted from the file's modification time.
+	FromTime time.Time
+	Limit    int       // maximum number of entries
+	Levels   []string  // only include these levels (empty means all)
+	Grep     string    // case-insensitive substring the message must contain
+	Since    time.Time // skip entries older than this
+}
+
+type LogPage struct {
+	Entries []LogEntry `json:"entries"` // in chronological order
+	Next    int64      `json:"next"`    // cursor for the next (older) page
+	// NextTime is the time of the entry at Next, pass it along with the cursor
+	NextTime time.Time `json:"nextTime"`
+	HasMore  bool      `json:"hasMore"`
+	End      int64     `json:"end"` // size of the file when it was read
+}
+
+// ReadLogPage reads the log file backwards from q.From and returns up to q.Limit matching entries.
+// Lines only carry the time of day, the date is taken from the file's modification time (or
+// q.FromTime for older pages) and moved one day back every time the clock wraps around midnight.
+func ReadLogPage(path string, q LogQuery) (LogPage, error) {
+	page := LogPage{Entries: []LogEntry{}}
+
+	if q.Limit <= 0 {
+		q.Limit = DefaultLogLimit
+	}
+	if q.Limit > MaxLogLimit {
+		q.Limit = MaxLogLimit
+	}
+	for i, level := range q.Levels {
+		q.Levels[i] = strings.ToUpper(level)
+	}
+	grep := strings.ToLower(q.Grep)
+
 	file, err := os.Open(path)
 	if err != nil {
-		return nil, err
+		return page, err
 	}
 	defer file.Close()
 
-	var lines []string
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		lines = append(lines, scanner.Text())
+	info, err := file.Stat()
+	if err != nil {
+		return page, err
+	}
+	page.End = info.Size()
+
+	from := q.From
+	if from < 0 || from > info.Size() {
+		from = info.Size()
+	}
+
+	day := info.ModTime()
+	lastClock := 24 * time.Hour
+	if !q.FromTime.IsZero() && from < info.Size() {
+		day = q.FromTime.In(day.Location())
+		lastClock = timeOfDay(day)
+	}
+	var (
+		continuation []string
+		reachedSince bool
+	)
+
+	err = readLinesBackward(file, from, func(line string, start int64) bool {
+		match := logLineRegex.FindStringSubmatch(line)
+		if match == nil {
+			continuation = append([]string{line}, continuation...)
+			return true
+		}
+
+		entry := LogEntry{
+			Offset:  start,
+			Thread:  match[2],
+			Level:   match[3],
+			Message: match[4],
+		}
+		if len(continuation) > 0 {
+			entry.Message += "\n" + strings.Join(continuation, "\n")
+			continuation = nil
+		}
+
+		if clock, err := time.Parse("15:04:05", match[1]); err == nil {
+			sinceMidnight := timeOfDay(clock)
+			if sinceMidnight > lastClock {
+				day = day.AddDate(0, 0, -1)
+			}
+			lastClock = sinceMidnight
+			y, m, d := day.Date()
+			entry.Time = time.Date(y, m, d, 0, 0, 0, 0, day.Location()).Add(sinceMidnight)
+		}
+
+		page.Next = start
+		if !entry.Time.IsZero() {
+			page.NextTime = entry.Time
+		}
+
+		if !q.Since.IsZero() && !entry.Time.IsZero() && entry.Time.Before(q.Since) {
+			reachedSince = true
+			return false
+		}
+		if len(q.Levels) > 0 && !contains(q.Levels, entry.Level) {
+			return true
+		}
+		if grep != "" && !strings.Contains(strings.ToLower(entry.Message), grep) {
+			return true
+		}
+
+		page.Entries = append(page.Entries, entry)
+		return len(page.Entries) < q.Limit
+	})
+	if err != nil {
+		return page, err
+	}
+
+	page.HasMore = page.Next > 0 && !reachedSince
+
+	// entries were collected newest first
+	for i, j := 0, len(page.Entries)-1; i < j; i, j = i+1, j-1 {
+		page.Entries[i], page.Entries[j] = page.Entries[j], page.Entries[i]
+	}
+
+	return page, nil
+}
+
+func timeOfDay(t time.Time) time.Duration {
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+}
+
+// readLinesBackward calls fn for every line ending before end, starting with the last one,
+// until fn returns false. start is the byte offset of the line.
+func readLinesBackward(file *os.File, end int64, fn func(line string, start int64) bool) error {
+	pos := end
+	atEnd := true
+	var carry []byte
+
+	for pos > 0 {
+		n := int64(logReadChunk)
+		if n > pos {
+			n = pos
+		}
+		pos -= n
+
+		buf := make([]byte, n, n+int64(len(carry)))
+		if _, err := file.ReadAt(buf, pos); err != nil && err != io.EOF {
+			return err
+		}
+		data := append(buf, carry...)
+
+		idx := len(data)
+		for {
+			i := bytes.LastIndexByte(data[:idx], '\n')
+			if i < 0 {
+				break
+			}
+			line := data[i+1 : idx]
+			// skip the empty "line" after the final newline
+			if !(atEnd && idx == len(data) && len(line) == 0) {
+				if !fn(trimNewline(string(line)), pos+int64(i+1)) {
+					return nil
+				}
+			}
+			atEnd = false
+			idx = i
+		}
+		carry = append([]byte(nil), data[:idx]...)
+	}
+
+	if end > 0 && (len(carry) > 0 || !atEnd) {
+		fn(trimNewline(string(carry)), 0)
+	}
+	return nil
+}
+
+// LogLine is a single line emitted by TailLog along with the offset right after it
+type LogLine struct {
+	Text    string
+	Offset  int64
+	Rotated bool // set on the first line read after the file was rotated or truncated
+}
+
+// TailLog follows the file at path starting at offset (or at the end when offset is negative)
+// and calls emit for every complete line. The file is reopened when the server rotates it.
+func TailLog(ctx context.Context, path string, offset int64, emit func(LogLine) error) error {
+	var (
+		file    *os.File
+		info    os.FileInfo
+		reader  *bufio.Reader
+		partial string
+		rotated bool
+	)
+	defer func() {
+		if file != nil {
+			file.Close()
+		}
+	}()
+
+	open := func(start int64) error {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return err
+		}
+		if start < 0 || start > fi.Size() {
+			start = fi.Size()
+		}
+		if _, err := f.Seek(start, io.SeekStart); err != nil {
+			f.Close()
+			return err
+		}
+		if file != nil {
+			file.Close()
+		}
+		file, info, offset, partial = f, fi, start, ""
+		reader = bufio.NewReader(f)
+		return nil
+	}
+
+	for {
+		if file == nil {
+			if err := open(offset); err != nil {
+				if !os.IsNotExist(err) {
+					return err
+				}
+				// read a log that appears later from its beginning
+				offset = 0
+			}
+		}
+
+		if file != nil {
+			for {
+				chunk, err := reader.ReadString('\n')
+				offset += int64(len(chunk))
+				if err == nil {
+					line := LogLine{Text: trimNewline(partial + chunk), Offset: offset, Rotated: rotated}
+					partial, rotated = "", false
+					if err := emit(line); err != nil {
+						return err
+					}
+					continue
+				}
+				if err != io.EOF {
+					return err
+				}
+				partial += chunk
+				break
+			}
+
+			// detect rotation (file replaced) or truncation
+			current, err := os.Stat(path)
+			switch {
+			case err == nil && !os.SameFile(info, current):
+				if err := open(0); err == nil {
+					rotated = true
+					continue
+				}
+			case err == nil && current.Size() < offset:
+				if err := open(0); err == nil {
+					rotated = true
+					continue
+				}
+			case os.IsNotExist(err):
+				// the server is rolling the log, wait for a new one
+				file.Close()
+				file, offset, rotated = nil, 0, true
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(logPollInterval):
+		}
+	}
+}
+
+func trimNewline(s string) string {
+	if len(s) > 0 && s[len(s)-1] == '\n' {
+		s = s[:len(s)-1]
+	}
+	if len(s) > 0 && s[len(s)-1] == '\r' {
+		s = s[:len(s)-1]
 	}
-	return lines, scanner.Err()
+	return s
 }
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestReadLogPageDatesOlderPages reads a log spanning three days one entry at a time
+func TestReadLogPageDatesOlderPages(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "latest.log")
+	lines := []string{
+		"[22:00:00] [Server thread/INFO]: first day",
+		"[23:00:00] [Server thread/INFO]: first day, later",
+		"[21:00:00] [Server thread/INFO]: second day",
+		"[01:00:00] [Server thread/INFO]: third day",
+		"[02:00:00] [Server thread/INFO]: third day, later",
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Date(2024, 1, 3, 3, 0, 0, 0, time.Local)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []time.Time{
+		time.Date(2024, 1, 3, 2, 0, 0, 0, time.Local),
+		time.Date(2024, 1, 3, 1, 0, 0, 0, time.Local),
+		time.Date(2024, 1, 2, 21, 0, 0, 0, time.Local),
+		time.Date(2024, 1, 1, 23, 0, 0, 0, time.Local),
+		time.Date(2024, 1, 1, 22, 0, 0, 0, time.Local),
+	}
+	q := LogQuery{From: -1, Limit: 1}
+	for i, at := range want {
+		page, err := ReadLogPage(path, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Entries) != 1 || !page.Entries[0].Time.Equal(at) {
+			t.Fatalf("page %d = %+v, want an entry at %v", i, page.Entries, at)
+		}
+		if page.HasMore != (i < len(want)-1) {
+			t.Errorf("page %d: HasMore = %v", i, page.HasMore)
+		}
+		q.From, q.FromTime = page.Next, page.NextTime
+	}
+
+	// the since filter stops at the right entry of an older page
+	page, err := ReadLogPage(path, LogQuery{From: -1, Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err = ReadLogPage(path, LogQuery{From: page.Next, FromTime: page.NextTime, Since: want[2]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Entries) != 1 || page.Entries[0].Message != "second day" || page.HasMore {
+		t.Errorf("page since %v = %+v, HasMore %v", want[2], page.Entries, page.HasMore)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultLogSearchLimit = 200
+	MaxLogSearchLimit     = 2000
+)
+
+// rotated logs are named like 2024-08-31-1.log.gz
+var logArchiveRegex = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2})-(\d+)\.log\.gz$`)
+
+type LogArchive struct {
+	Name  string    `json:"name"`
+	Date  time.Time `json:"date"`
+	Index int       `json:"index"`
+	Size  int64     `json:"size"`
+}
+
+type LogSearchResult struct {
+	Archive string `json:"archive"`
+	Line    int    `json:"line"` // 1-based line number within the archive
+	Text    string `json:"text"`
+}
+
+// ListLogArchives returns the rotated logs in dir, newest first
+func ListLogArchives(dir string) ([]LogArchive, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []LogArchive{}, nil
+		}
+		return nil, err
+	}
+
+	archives := []LogArchive{}
+	for _, file := range files {
+		match := logArchiveRegex.FindStringSubmatch(file.Name())
+		if file.IsDir() || match == nil {
+			continue
+		}
+		date, err := time.Parse("2006-01-02", match[1])
+		if err != nil {
+			continue
+		}
+		index, _ := strconv.Atoi(match[2])
+
+		info, err := file.Info()
+		if err != nil {
+			return nil, err
+		}
+
+		archives = append(archives, LogArchive{
+			Name:  file.Name(),
+			Date:  date,
+			Index: index,
+			Size:  info.Size(),
+		})
+	}
+
+	sort.Slice(archives, func(i, k int) bool {
+		if archives[i].Date.Equal(archives[k].Date) {
+			return archives[i].Index > archives[k].Index
+		}
+		return archives[i].Date.After(archives[k].Date)
+	})
+
+	return archives, nil
+}
+
+// openLogArchive opens a rotated log for reading, transparently decompressing it
+func openLogArchive(dir string, name string) (io.ReadCloser, error) {
+	if !logArchiveRegex.MatchString(name) {
+		return nil, fmt.Errorf("invalid log archive name %q", name)
+	}
+
+	file, err := os.Open(filepath.Join(dir, name))
+	if err != nil {
+		return nil, err
+	}
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to decompress %s: %v", name, err)
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{gz, closerFunc(func() error {
+		gz.Close()
+		return file.Close()
+	})}, nil
+}
+
+// ReadLogArchive returns all lines of a rotated log
+func ReadLogArchive(dir string, name string) ([]string, error) {
+	reader, err := openLogArchive(dir, name)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
+
+// SearchLogArchives looks for query (case-insensitive) in rotated logs dated between from and to (inclusive, zero means unbounded)
+func SearchLogArchives(dir string, query string, from, to time.Time, limit int) ([]LogSearchResult, error) {
+	if limit <= 0 {
+		limit = DefaultLogSearchLimit
+	}
+	if limit > MaxLogSearchLimit {
+		limit = MaxLogSearchLimit
+	}
+	query = strings.ToLower(query)
+
+	archives, err := ListLogArchives(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []LogSearchResult{}
+	for _, archive := range archives {
+		if (!from.IsZero() && archive.Date.Before(from)) || (!to.IsZero() && archive.Date.After(to)) {
+			continue
+		}
+
+		if err := searchLogArchive(dir, archive.Name, query, limit, &results); err != nil {
+			return results, err
+		}
+		if len(results) >= limit {
+			break
+		}
+	}
+
+	return results, nil
+}
+
+func searchLogArchive(dir string, name string, query string, limit int, results *[]LogSearchResult) error {
+	reader, err := openLogArchive(dir, name)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if strings.Contains(strings.ToLower(scanner.Text()), query) {
+			*results = append(*results, LogSearchResult{
+				Archive: name,
+				Line:    line,
+				Text:    scanner.Text(),
+			})
+			if len(*results) >= limit {
+				return nil
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read %s: %v", name, err)
+	}
+	return nil
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
+}
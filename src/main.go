@@ -4,7 +4,11 @@ import (
 	"fmt"
 	"log"
 	"math/rand/v2"
+	"net"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
@@ -13,6 +17,8 @@ import (
 	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
+const stateDir = "state"
+
 func main() {
 
 	// create backups directory
@@ -25,6 +31,15 @@ func main() {
 		}
 	}
 
+	// create directory for persisted state (schedules, ...)
+
+	doesExist, _ = exists(stateDir)
+	if !doesExist {
+		if err := os.Mkdir(stateDir, os.FileMode(0755)); err != nil {
+			log.Fatalln("cannot create directory", err)
+		}
+	}
+
 	//init server
 	bindPath, err := os.Getwd()
 	if err != nil {
@@ -37,13 +52,32 @@ func main() {
 	img := "itzg/minecraft-server"
 	cn := "bebok"
 
-	runner := InitRunner(img, cn, bindPath)
+	rconPassword := os.Getenv("RCON_PASSWORD")
+	if rconPassword == "" {
+		if rconPassword, err = generatedRCONPassword(); err != nil {
+			log.Fatalln("failed to set up the RCON password", err)
+		}
+	}
+	rconHost := os.Getenv("RCON_HOST")
+	if rconHost == "" {
+		rconHost = "127.0.0.1"
+	}
+
+	stopTimeout := 60 * time.Second
+	if v := os.Getenv("STOP_TIMEOUT"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil {
+			log.Fatalln("invalid STOP_TIMEOUT", err)
+		}
+		stopTimeout = time.Duration(seconds) * time.Second
+	}
 
-	// create bucket controller
-	bucketName := os.Getenv("BACKUPS_BUCKET")
-	projectID := os.Getenv("PROJECT_ID")
+	runner := InitRunner(img, cn, bindPath, rconPassword)
+	runner.RCONAddr = net.JoinHostPort(rconHost, "25575")
+	runner.StopTimeout = stopTimeout
 
-	bucket, err := InitBucket(bucketName, projectID)
+	// create backup storage backend (GCS, S3 or local directory)
+	store, err := InitBackupStore()
 	if err != nil {
 		log.Fatalln(err)
 	}
@@ -53,22 +87,85 @@ func main() {
 
 	secret := os.Getenv("JWT_SECRET")
 
-	server := NewAPIServer(listenPort, templatePath, logPath, runner, bucket, secret)
+	retention, err := RetentionPolicyFromEnv()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	if key, old := os.Getenv("BACKUP_ENCRYPTION_KEY"), os.Getenv("BACKUP_DECRYPTION_KEYS"); key != "" || old != "" {
+		backupKeys, err = NewKeyring(key, strings.Split(old, ","))
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if id := backupKeys.CurrentKeyID(); id != "" {
+			log.Printf("backups are encrypted with key %s\n", id)
+		}
+	}
+
+	server := NewAPIServer(listenPort, templatePath, logPath, runner, store, secret)
+	server.Retention = retention
+
+	backupFormat, err := ParseArchiveFormat(os.Getenv("BACKUP_FORMAT"))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	server.BackupFormat = backupFormat
+
+	syncPolicy, err := ParseSyncPolicy(os.Getenv("SYNC_POLICY"))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	server.Syncer.Policy = syncPolicy
+	if v := os.Getenv("SYNC_MAX_DELETE_SHARE"); v != "" {
+		share, err := strconv.ParseFloat(v, 64)
+		if err != nil || share < 0 || share > 1 {
+			log.Fatalln("invalid SYNC_MAX_DELETE_SHARE", v)
+		}
+		server.Syncer.MaxDeleteShare = share
+	}
+
+	if v := os.Getenv("TRANSFER_CONCURRENCY"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			log.Fatalln("invalid TRANSFER_CONCURRENCY", v)
+		}
+		server.Transfers.Concurrency = n
+	}
+	if v := os.Getenv("TRANSFER_RETRIES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			log.Fatalln("invalid TRANSFER_RETRIES", v)
+		}
+		server.Transfers.Retries = n
+	}
+
+	if v := os.Getenv("VERIFY_INTERVAL"); v != "" {
+		interval, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalln("invalid VERIFY_INTERVAL", err)
+		}
+		server.Verifier.Interval = interval
+	}
 
 	server.Run()
 }
 
-func InitBucket(bucketName, projectID string) (*Bucket, error) {
-	bucket, err := NewBucket(bucketName, projectID)
+func InitBackupStore() (BackupStore, error) {
+	store, err := NewBackupStoreFromEnv()
 	if err != nil {
-		log.Fatalln(err)
 		return nil, err
 	}
 
-	return bucket, nil
+	if store == nil {
+		log.Println("backup storage not configured, cloud sync disabled")
+		return nil, nil
+	}
+	log.Printf("using backup storage %s\n", store.Name())
+
+	return store, nil
 }
 
-func InitRunner(containerImage, containerName, bindPath string) *ContainerRunner {
+func InitRunner(containerImage, containerName, bindPath, rconPassword string) *ContainerRunner {
 	img := containerImage
 	cn := containerName
 
@@ -76,13 +173,17 @@ func InitRunner(containerImage, containerName, bindPath string) *ContainerRunner
 	if err != nil {
 		panic(err)
 	}
+	rconPort, err := nat.NewPort("tcp", "25575")
+	if err != nil {
+		panic(err)
+	}
 	networkName := fmt.Sprintf("mcnet-%d", rand.IntN(10000))
 
 	conf := container.Config{
 		Hostname:     "minecraft",
 		Image:        img,
-		ExposedPorts: nat.PortSet{ports: struct{}{}},
-		Env:          []string{"EULA=TRUE"},
+		ExposedPorts: nat.PortSet{ports: struct{}{}, rconPort: struct{}{}},
+		Env:          []string{"EULA=TRUE", "ENABLE_RCON=TRUE", "RCON_PASSWORD=" + rconPassword},
 	}
 	hostconf := container.HostConfig{
 		Resources: container.Resources{
@@ -98,6 +199,13 @@ func InitRunner(containerImage, containerName, bindPath string) *ContainerRunner
 					HostPort: "25565",
 				},
 			},
+			// rcon is only reachable from the host running the management server
+			"25575/tcp": []nat.PortBinding{
+				{
+					HostIP:   "127.0.0.1",
+					HostPort: "25575",
+				},
+			},
 		},
 		AutoRemove:  true,
 		NetworkMode: container.NetworkMode(container.NetworkMode(networkName).NetworkName()),
@@ -127,6 +235,7 @@ func InitRunner(containerImage, containerName, bindPath string) *ContainerRunner
 		platform,
 		pullopts,
 		startopts)
+	runner.RCONPassword = rconPassword
 
 	return runner
 }
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const manifestSuffix = ".manifest.json"
+
+var (
+	mcVersionRegex = regexp.MustCompile(`Starting minecraft server version (\S+)`)
+	seedRegex      = regexp.MustCompile(`Seed: \[(-?\d+)\]`)
+)
+
+// BackupManifest is stored next to every backup as <backup>.manifest.json
+type BackupManifest struct {
+	Name             string        `json:"name"`
+	Created          time.Time     `json:"created"`
+	SHA256           string        `json:"sha256"`
+	Size             int64         `json:"size"`
+	Format           ArchiveFormat `json:"format"`
+	KeyID            string        `json:"keyId,omitempty"` // key the backup is encrypted with, empty if it is not
+	FileCount        int           `json:"fileCount"`
+	MinecraftVersion string        `json:"minecraftVersion,omitempty"`
+	Seed             string        `json:"seed,omitempty"`
+	Image            string        `json:"image,omitempty"`
+	ImageDigest      string        `json:"imageDigest,omitempty"`
+	TriggeredBy      string        `json:"triggeredBy,omitempty"`
+	ServerRunning    bool          `json:"serverRunning"`
+	Consistent       bool          `json:"consistent"` // world saving was paused while archiving (or the server was stopped)
+	Warnings         []string      `json:"warnings,omitempty"`
+}
+
+func manifestPath(backupName string) string {
+	return filepath.Join(backupsDir, backupName+manifestSuffix)
+}
+
+func writeManifest(manifest BackupManifest) error {
+	return writeJSONFile(manifestPath(manifest.Name), manifest)
+}
+
+// readManifest returns os.ErrNotExist if the backup has no manifest
+func readManifest(backupName string) (BackupManifest, error) {
+	var manifest BackupManifest
+	if _, err := os.Stat(manifestPath(backupName)); err != nil {
+		return manifest, err
+	}
+	err := readJSONFile(manifestPath(backupName), &manifest)
+	return manifest, err
+}
+
+// manifestMu keeps two requests from computing and writing the same manifest
+var manifestMu sync.Mutex
+
+// savedBackupInfo returns the saved manifest of a local backup without reading the archive, ok is
+// false if there is none or it describes an archive of another size
+func savedBackupInfo(backupName string) (BackupManifest, bool) {
+	info, err := os.Stat(filepath.Join(backupsDir, backupName))
+	if err != nil {
+		return BackupManifest{}, false
+	}
+	manifest, err := readManifest(backupName)
+	if err != nil || manifest.Size != info.Size() {
+		return BackupManifest{}, false
+	}
+	return manifest, true
+}
+
+// BackupInfo returns the manifest of a local backup. Backups without one (created before
+// manifests existed, uploaded or downloaded) get one computed from the archive, without
+// provenance, which is saved so the archive is only read once.
+func BackupInfo(backupName string) (BackupManifest, error) {
+	if !backupNameRegex.MatchString(backupName) {
+		return BackupManifest{}, fmt.Errorf("invalid backup name %q", backupName)
+	}
+	if manifest, ok := savedBackupInfo(backupName); ok {
+		return manifest, nil
+	}
+
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+	if manifest, ok := savedBackupInfo(backupName); ok {
+		return manifest, nil
+	}
+
+	path := filepath.Join(backupsDir, backupName)
+	info, err := os.Stat(path)
+	if err != nil {
+		return BackupManifest{}, err
+	}
+	manifest := BackupManifest{
+		Name:     backupName,
+		Created:  info.ModTime(),
+		Warnings: []string{"backup has no manifest, provenance is unknown"},
+	}
+	if err := fillArchiveInfo(&manifest, path); err != nil {
+		return manifest, err
+	}
+	if !readableBackup(backupName) {
+		manifest.Warnings = append(manifest.Warnings, "backup format is not supported, it can only be downloaded or deleted")
+	}
+	if err := writeManifest(manifest); err != nil {
+		return manifest, fmt.Errorf("failed to save manifest of %s: %v", backupName, err)
+	}
+	return manifest, nil
+}
+
+// describeBackups computes the manifests of local backups that have none. It runs in the
+// background after backups arrived without a manifest, pages only show saved manifests.
+func describeBackups() {
+	names, err := GetAvailableBackups(backupsDir)
+	if err != nil {
+		log.Printf("Error listing backups: %v", err)
+		return
+	}
+	for _, name := range names {
+		if _, ok := savedBackupInfo(name); ok {
+			continue
+		}
+		if _, err := BackupInfo(name); err != nil {
+			log.Printf("Error describing backup %s: %v", name, err)
+		}
+	}
+}
+
+// fillArchiveInfo sets checksum, size and encryption key of the archive at path, the file count only if it is not known yet.
+// Format and file count stay empty for archives in an unsupported format.
+func fillArchiveInfo(manifest *BackupManifest, path string) error {
+	sum, size, err := fileSHA256(path)
+	if err != nil {
+		return err
+	}
+	manifest.SHA256 = sum
+	manifest.Size = size
+	if manifest.KeyID, err = backupKeyID(path); err != nil {
+		return err
+	}
+
+	if manifest.Format == "" {
+		if manifest.Format, err = archiveFormatOf(path); errors.Is(err, ErrUnsupportedFormat) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+	if manifest.FileCount == 0 {
+		if manifest.FileCount, err = archiveFileCount(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func fileSHA256(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
+
+// minecraftVersion reads the server version from the startup line of latest.log
+func minecraftVersion(logsPath string) string {
+	f, err := os.Open(logsPath)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if m := mcVersionRegex.FindStringSubmatch(scanner.Text()); m != nil {
+			return m[1]
+		}
+	}
+	return ""
+}
+
+// worldSeed asks the running server for its seed, falling back to level-seed from server.properties
+func worldSeed(rcon *RCONClient) string {
+	if rcon != nil {
+		if resp, err := rcon.Command("seed"); err == nil {
+			if m := seedRegex.FindStringSubmatch(resp); m != nil {
+				return m[1]
+			}
+		}
+	}
+
+	f, err := os.Open(filepath.Join("mcdata", "server.properties"))
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(scanner.Text(), "level-seed="); ok {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestBackupInfoSavesComputedManifest(t *testing.T) {
+	chdirTemp(t)
+	name := "world_20240101_000000.zip"
+	path := filepath.Join(backupsDir, name)
+	writeTestZip(t, path, []testEntry{{name: "world/level.dat", body: "level"}})
+
+	if _, ok := savedBackupInfo(name); ok {
+		t.Fatal("backup without a manifest has a saved one")
+	}
+	manifest, err := BackupInfo(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.FileCount != 1 || manifest.SHA256 == "" || len(manifest.Warnings) == 0 {
+		t.Errorf("computed manifest = %+v", manifest)
+	}
+	saved, ok := savedBackupInfo(name)
+	if !ok || saved.SHA256 != manifest.SHA256 {
+		t.Fatalf("saved manifest = %+v, %v", saved, ok)
+	}
+
+	// a replaced archive gets a new manifest
+	writeTestZip(t, path, []testEntry{{name: "world/level.dat", body: "level"}, {name: "world/region/r.0.0.mca", body: "region"}})
+	if _, ok := savedBackupInfo(name); ok {
+		t.Error("manifest of the replaced archive is still used")
+	}
+	if manifest, err := BackupInfo(name); err != nil || manifest.FileCount != 2 {
+		t.Errorf("manifest of the replaced archive = %+v, %v", manifest, err)
+	}
+
+	other := "world_20240102_000000.zip"
+	writeTestZip(t, filepath.Join(backupsDir, other), []testEntry{{name: "world/level.dat", body: "level"}})
+	describeBackups()
+	if _, ok := savedBackupInfo(other); !ok {
+		t.Error("describeBackups did not save a manifest")
+	}
+}
+
+// TestUnreadableBackupFormat keeps backups in formats older versions accepted listed but not readable
+func TestUnreadableBackupFormat(t *testing.T) {
+	dir := chdirTemp(t)
+	name := "world_20240101_000000.bz2"
+	writeTestBackup(t, name, "bzip2 data")
+
+	if got := listLocal(t); len(got) != 1 || got[0] != name {
+		t.Fatalf("local backups = %v", got)
+	}
+	manifest, err := BackupInfo(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.SHA256 == "" || manifest.Format != "" || len(manifest.Warnings) != 2 {
+		t.Errorf("manifest = %+v", manifest)
+	}
+
+	v := NewVerifier(filepath.Join(dir, "verify.json"), nil, nil)
+	if _, err := v.Verify(context.Background(), name, false); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("Verify = %v, want ErrUnsupportedFormat", err)
+	}
+	if err := extractArchivePaths(filepath.Join(backupsDir, name), filepath.Join(dir, "staging"), nil); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("extracting = %v, want ErrUnsupportedFormat", err)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RCON packet types as defined by the Source RCON protocol used by Minecraft
+const (
+	rconTypeResponse int32 = 0
+	rconTypeCommand  int32 = 2
+	rconTypeLogin    int32 = 3
+
+	rconMaxPayload = 1446
+
+	// rconTimeout is the default deadline for connecting and for a command's reply
+	rconTimeout = 10 * time.Second
+)
+
+// ErrRCONNoReply means a command was sent but its reply could not be read, the server may
+// still run it (e.g. "stop" closes the connection while the server shuts down)
+var ErrRCONNoReply = errors.New("no rcon reply")
+
+// RCONClient is a minimal client for the Minecraft remote console
+type RCONClient struct {
+	conn    net.Conn
+	timeout time.Duration
+	nextID  int32
+	mu      sync.Mutex
+}
+
+// rconPasswordFile keeps the password generated when RCON_PASSWORD is not set. The server
+// container outlives the manager and keeps the password it was created with.
+var rconPasswordFile = filepath.Join(stateDir, "rcon-password")
+
+// generatedRCONPassword returns the stored RCON password, one is generated on first use
+func generatedRCONPassword() (string, error) {
+	data, err := os.ReadFile(rconPasswordFile)
+	if err == nil && strings.TrimSpace(string(data)) != "" {
+		return strings.TrimSpace(string(data)), nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	password := newJobID()
+	if err := os.MkdirAll(stateDir, 0755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(rconPasswordFile, []byte(password+"\n"), 0600); err != nil {
+		return "", fmt.Errorf("failed to store RCON password: %v", err)
+	}
+	return password, nil
+}
+
+// DialRCON connects to the RCON server at addr and authenticates with password
+func DialRCON(addr string, password string, timeout time.Duration) (*RCONClient, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to rcon at %s: %v", addr, err)
+	}
+
+	c := &RCONClient{
+		conn:    conn,
+		timeout: timeout,
+	}
+
+	id, err := c.send(rconTypeLogin, password)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	respID, _, err := c.read(timeout)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if respID == -1 || respID != id {
+		conn.Close()
+		return nil, fmt.Errorf("rcon authentication failed")
+	}
+
+	return c, nil
+}
+
+// Command sends a server command and returns the server's response
+func (c *RCONClient) Command(cmd string) (string, error) {
+	return c.CommandTimeout(cmd, c.timeout)
+}
+
+// CommandTimeout is Command for commands that may take longer than the connection's timeout
+func (c *RCONClient) CommandTimeout(cmd string, timeout time.Duration) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(cmd) > rconMaxPayload {
+		return "", fmt.Errorf("rcon command too long (%d bytes)", len(cmd))
+	}
+
+	id, err := c.send(rconTypeCommand, cmd)
+	if err != nil {
+		return "", err
+	}
+
+	respID, body, err := c.read(timeout)
+	// skip late replies to earlier commands that timed out, they would answer this one
+	for err == nil && respID < id {
+		respID, body, err = c.read(timeout)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrRCONNoReply, err)
+	}
+	if respID != id {
+		return "", fmt.Errorf("%w: response id mismatch, sent %d, got %d", ErrRCONNoReply, id, respID)
+	}
+
+	return body, nil
+}
+
+func (c *RCONClient) Close() error {
+	return c.conn.Close()
+}
+
+func (c *RCONClient) send(packetType int32, body string) (int32, error) {
+	c.nextID++
+	id := c.nextID
+
+	// length + request id + type + body + two null bytes
+	payload := new(bytes.Buffer)
+	binary.Write(payload, binary.LittleEndian, int32(4+4+len(body)+2))
+	binary.Write(payload, binary.LittleEndian, id)
+	binary.Write(payload, binary.LittleEndian, packetType)
+	payload.WriteString(body)
+	payload.Write([]byte{0, 0})
+
+	c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	if _, err := c.conn.Write(payload.Bytes()); err != nil {
+		return 0, fmt.Errorf("failed to write rcon packet: %v", err)
+	}
+
+	return id, nil
+}
+
+func (c *RCONClient) read(timeout time.Duration) (int32, string, error) {
+	c.conn.SetReadDeadline(time.Now().Add(timeout))
+
+	var length int32
+	if err := binary.Read(c.conn, binary.LittleEndian, &length); err != nil {
+		return 0, "", fmt.Errorf("failed to read rcon packet length: %v", err)
+	}
+	if length < 10 || length > 4096+10 {
+		return 0, "", fmt.Errorf("invalid rcon packet length %d", length)
+	}
+
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(c.conn, buf); err != nil {
+		return 0, "", fmt.Errorf("failed to read rcon packet: %v", err)
+	}
+
+	id := int32(binary.LittleEndian.Uint32(buf[0:4]))
+	packetType := int32(binary.LittleEndian.Uint32(buf[4:8]))
+	if packetType != rconTypeResponse && packetType != rconTypeCommand {
+		return 0, "", fmt.Errorf("unexpected rcon packet type %d", packetType)
+	}
+
+	body := bytes.TrimRight(buf[8:], "\x00")
+	return id, string(body), nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRCON is a Minecraft RCON server, reply decides the answer to a command and whether the
+// connection is closed instead of answering
+type fakeRCON struct {
+	password string
+	reply    func(cmd string) (string, bool)
+
+	mu       sync.Mutex
+	commands []string
+}
+
+func startFakeRCON(t *testing.T, f *fakeRCON) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func (f *fakeRCON) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		var length, id, packetType int32
+		if err := binary.Read(conn, binary.LittleEndian, &length); err != nil {
+			return
+		}
+		buf := make([]byte, length)
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return
+		}
+		id = int32(binary.LittleEndian.Uint32(buf[0:4]))
+		packetType = int32(binary.LittleEndian.Uint32(buf[4:8]))
+		body := string(bytes.TrimRight(buf[8:], "\x00"))
+
+		reply := ""
+		if packetType == rconTypeLogin {
+			if body != f.password {
+				id = -1
+			}
+			packetType = rconTypeCommand
+		} else {
+			f.mu.Lock()
+			f.commands = append(f.commands, body)
+			f.mu.Unlock()
+
+			var hangUp bool
+			if reply, hangUp = f.reply(body); hangUp {
+				return
+			}
+			packetType = rconTypeResponse
+		}
+
+		out := new(bytes.Buffer)
+		binary.Write(out, binary.LittleEndian, int32(4+4+len(reply)+2))
+		binary.Write(out, binary.LittleEndian, id)
+		binary.Write(out, binary.LittleEndian, packetType)
+		out.WriteString(reply)
+		out.Write([]byte{0, 0})
+		if _, err := conn.Write(out.Bytes()); err != nil {
+			return
+		}
+	}
+}
+
+func (f *fakeRCON) sent() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.commands...)
+}
+
+func TestShutdownServerCountsStopWithoutReply(t *testing.T) {
+	tests := []struct {
+		name     string
+		hangUpOn string
+		stopSent bool
+	}{
+		{"reply", "", true},
+		{"connection closed by stop", "stop", true},
+		{"connection closed by save-all", "save-all", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &fakeRCON{password: "secret", reply: func(cmd string) (string, bool) {
+				return "ok", cmd == tt.hangUpOn
+			}}
+			r := &ContainerRunner{RCONAddr: startFakeRCON(t, f), RCONPassword: "secret"}
+
+			stopSent, err := r.shutdownServer()
+			if stopSent != tt.stopSent {
+				t.Errorf("stopSent = %v, want %v (err %v)", stopSent, tt.stopSent, err)
+			}
+			if (err != nil) != (tt.hangUpOn != "") {
+				t.Errorf("err = %v", err)
+			}
+		})
+	}
+}
+
+func TestRCONLostReply(t *testing.T) {
+	f := &fakeRCON{password: "secret", reply: func(cmd string) (string, bool) {
+		return "", cmd == "stop"
+	}}
+	addr := startFakeRCON(t, f)
+
+	if _, err := DialRCON(addr, "wrong", 5*time.Second); err == nil {
+		t.Error("login with a wrong password succeeded")
+	}
+	rcon, err := DialRCON(addr, "secret", 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rcon.Close()
+	if _, err := rcon.Command("stop"); !errors.Is(err, ErrRCONNoReply) {
+		t.Errorf("Command = %v, want ErrRCONNoReply", err)
+	}
+	if got := f.sent(); len(got) != 1 || got[0] != "stop" {
+		t.Errorf("server received %v", got)
+	}
+}
+
+// TestRCONSkipsLateReplies answers the next command correctly after a command timed out
+func TestRCONSkipsLateReplies(t *testing.T) {
+	f := &fakeRCON{password: "secret", reply: func(cmd string) (string, bool) {
+		if cmd == "save-all flush" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		return "reply to " + cmd, false
+	}}
+	rcon, err := DialRCON(startFakeRCON(t, f), "secret", 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rcon.Close()
+
+	if _, err := rcon.Command("save-all flush"); !errors.Is(err, ErrRCONNoReply) {
+		t.Fatalf("Command = %v, want ErrRCONNoReply", err)
+	}
+	if resp, err := rcon.CommandTimeout("save-on", time.Second); err != nil || resp != "reply to save-on" {
+		t.Errorf("command after a timeout = %q, %v", resp, err)
+	}
+	if resp, err := rcon.CommandTimeout("save-all flush", time.Second); err != nil || resp != "reply to save-all flush" {
+		t.Errorf("CommandTimeout = %q, %v", resp, err)
+	}
+}
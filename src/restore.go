@@ -0,0 +1,310 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	dataDir            = "mcdata"
+	restoreStagingDir  = "mcdata.staging"  // backup is extracted here first
+	restorePreviousDir = "mcdata.previous" // replaced data, kept until the server started
+
+	// how long the server has to stay up after a restore to count as started
+	restoreStartCheck = 30 * time.Second
+)
+
+// LoadBackupFromDisk restores a local backup into mcdata. With paths set, only those files or
+// directories (e.g. "world_nether" or "plugins/Essentials") are restored and the rest of mcdata
+// is left untouched, otherwise the whole directory is replaced.
+//
+// The backup is extracted to a staging directory and validated first, then swapped in by renaming.
+// If the server does not start afterwards, the replaced data is moved back and the server is
+// started again.
+func (s *APIServer) LoadBackupFromDisk(backupFile string, paths []string, progress ProgressFunc) error {
+	archivePath, err := backupPath(backupFile)
+	if err != nil {
+		return err
+	}
+	if _, err := archiveFormatOf(backupFile); err != nil {
+		return err
+	}
+	if _, err := os.Stat(archivePath); err != nil {
+		return err
+	}
+
+	return s.restoreData("backup "+backupFile, paths, progress, func(staging string, paths []string) error {
+		return extractArchivePaths(archivePath, staging, paths)
+	})
+}
+
+// LoadSnapshot restores an incremental snapshot the same way as LoadBackupFromDisk
+func (s *APIServer) LoadSnapshot(name string, paths []string, progress ProgressFunc) error {
+	if _, err := s.Snapshots.Get(name); err != nil {
+		return err
+	}
+
+	return s.restoreData("snapshot "+name, paths, progress, func(staging string, paths []string) error {
+		return s.Snapshots.Restore(name, staging, paths)
+	})
+}
+
+// restoreData stops the server, snapshots mcdata, lets extract fill the staging directory
+// and swaps the staged data in
+func (s *APIServer) restoreData(source string, paths []string, progress ProgressFunc, extract func(staging string, paths []string) error) error {
+	paths, err := normalizeRestorePaths(paths)
+	if err != nil {
+		return err
+	}
+
+	defer s.lockServer(progress)()
+	if ok, _ := exists(restorePreviousDir); ok {
+		return fmt.Errorf("%s exists, an earlier restore did not finish, move it away before restoring", restorePreviousDir)
+	}
+
+	// the whole directory is swapped as a single unit
+	units := paths
+	if len(units) == 0 {
+		units = []string{""}
+		log.Printf("restoring %s\n", source)
+	} else {
+		log.Printf("restoring %s from %s\n", strings.Join(paths, ", "), source)
+	}
+
+	progress.report("stopping server")
+	if err := s.Runner.StopContainer(nil); err != nil {
+		return fmt.Errorf("failed to stop server: %v", err)
+	}
+
+	progress.report("snapshotting current data")
+	snapshot := fmt.Sprintf("mcdata_%s.%s", time.Now().Format("20060102_150405"), s.BackupFormat)
+	if _, err := createArchive(dataDir, filepath.Join(backupsDir, snapshot)); err != nil {
+		return s.abortRestore(fmt.Errorf("failed to snapshot current data: %v", err))
+	}
+	log.Printf("pre-restore snapshot %s created\n", snapshot)
+
+	progress.report("extracting backup")
+	if err := os.RemoveAll(restoreStagingDir); err != nil {
+		return s.abortRestore(err)
+	}
+	defer os.RemoveAll(restoreStagingDir)
+	if err := extract(restoreStagingDir, paths); err != nil {
+		return s.abortRestore(fmt.Errorf("failed to extract %s: %v", source, err))
+	}
+
+	progress.report("validating")
+	if len(paths) == 0 {
+		err = validateDataDir(restoreStagingDir)
+	} else {
+		err = validateRestorePaths(restoreStagingDir, paths)
+	}
+	if err != nil {
+		return s.abortRestore(fmt.Errorf("%s cannot be restored: %v", source, err))
+	}
+
+	progress.report("swapping data")
+	replaced, err := swapRestoreUnits(units)
+	if err != nil {
+		return s.abortRestore(fmt.Errorf("failed to swap data: %v", err))
+	}
+
+	progress.report("starting server")
+	if err := s.startAndCheck(); err != nil {
+		log.Printf("server failed to start after restoring %s, rolling back: %v", source, err)
+		progress.report("rolling back")
+		if rerr := s.rollbackRestore(units, replaced); rerr != nil {
+			return fmt.Errorf("server failed to start (%v) and rollback failed: %v, previous data is in %s and %s", err, rerr, restorePreviousDir, snapshot)
+		}
+		return fmt.Errorf("server failed to start, restored previous data: %v", err)
+	}
+
+	if err := os.RemoveAll(restorePreviousDir); err != nil {
+		log.Printf("Error removing %s: %v", restorePreviousDir, err)
+	}
+	log.Printf("%s restored\n", source)
+
+	return nil
+}
+
+// normalizeRestorePaths cleans the selected paths, rejects unsafe ones and drops paths
+// already covered by a selected parent directory
+func normalizeRestorePaths(paths []string) ([]string, error) {
+	var cleaned []string
+	for _, p := range paths {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if strings.HasPrefix(p, "/") {
+			return nil, fmt.Errorf("%w: %q is absolute", ErrUnsafePath, p)
+		}
+		p = path.Clean(strings.TrimSuffix(p, "/"))
+		if p == "." {
+			continue
+		}
+		if _, err := safeJoin(dataDir, p); err != nil {
+			return nil, err
+		}
+		cleaned = append(cleaned, p)
+	}
+
+	var result []string
+	for _, p := range cleaned {
+		if !underAny(p, result) {
+			// a parent replaces children selected before it
+			var kept []string
+			for _, r := range result {
+				if !underAny(r, []string{p}) {
+					kept = append(kept, r)
+				}
+			}
+			result = append(kept, p)
+		}
+	}
+	return result, nil
+}
+
+// validateRestorePaths checks that every selected path was found in the backup
+func validateRestorePaths(dir string, paths []string) error {
+	for _, p := range paths {
+		if ok, _ := exists(filepath.Join(dir, filepath.FromSlash(p))); !ok {
+			return fmt.Errorf("%s not found in backup", p)
+		}
+	}
+	return nil
+}
+
+// swapRestoreUnits moves each unit of mcdata to the previous directory and the staged unit
+// into its place, it returns which units existed before. On error all swapped units are undone.
+func swapRestoreUnits(units []string) (map[string]bool, error) {
+	replaced := map[string]bool{}
+	var done []string
+
+	for _, unit := range units {
+		current := filepath.Join(dataDir, filepath.FromSlash(unit))
+		previous := filepath.Join(restorePreviousDir, filepath.FromSlash(unit))
+		staged := filepath.Join(restoreStagingDir, filepath.FromSlash(unit))
+
+		err := func() error {
+			if ok, _ := exists(current); ok {
+				if err := os.MkdirAll(filepath.Dir(previous), 0755); err != nil {
+					return err
+				}
+				if err := os.Rename(current, previous); err != nil {
+					return err
+				}
+				replaced[unit] = true
+			}
+			if err := os.MkdirAll(filepath.Dir(current), 0755); err != nil {
+				return err
+			}
+			return os.Rename(staged, current)
+		}()
+		if err != nil {
+			// the failed unit may have been moved away already
+			if replaced[unit] {
+				if rerr := os.Rename(previous, current); rerr != nil {
+					return nil, fmt.Errorf("%v, moving %s back failed as well: %v", err, current, rerr)
+				}
+			}
+			if rerr := restoreUnits(done, replaced); rerr != nil {
+				return nil, fmt.Errorf("%v, undoing the swap failed as well: %v", err, rerr)
+			}
+			return nil, err
+		}
+		done = append(done, unit)
+	}
+
+	return replaced, nil
+}
+
+// restoreUnits moves units from the previous directory back to mcdata
+func restoreUnits(units []string, replaced map[string]bool) error {
+	for _, unit := range units {
+		current := filepath.Join(dataDir, filepath.FromSlash(unit))
+		previous := filepath.Join(restorePreviousDir, filepath.FromSlash(unit))
+
+		if !replaced[unit] {
+			if err := os.RemoveAll(current); err != nil {
+				return err
+			}
+			continue
+		}
+		if ok, _ := exists(previous); !ok {
+			continue
+		}
+		if err := os.RemoveAll(current); err != nil {
+			return err
+		}
+		if err := os.Rename(previous, current); err != nil {
+			return err
+		}
+	}
+	return os.RemoveAll(restorePreviousDir)
+}
+
+// abortRestore starts the server again on the untouched data and returns err
+func (s *APIServer) abortRestore(err error) error {
+	if startErr := s.Runner.Containerize(nil); startErr != nil {
+		log.Printf("Error starting server after failed restore: %v", startErr)
+	}
+	return err
+}
+
+// rollbackRestore puts the replaced data back and starts the server
+func (s *APIServer) rollbackRestore(units []string, replaced map[string]bool) error {
+	if err := s.Runner.StopContainer(nil); err != nil {
+		return err
+	}
+	if err := restoreUnits(units, replaced); err != nil {
+		return err
+	}
+	return s.Runner.Containerize(nil)
+}
+
+// startAndCheck starts the server and makes sure it keeps running for restoreStartCheck
+func (s *APIServer) startAndCheck() error {
+	if err := s.Runner.Containerize(nil); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(restoreStartCheck)
+	for time.Now().Before(deadline) {
+		time.Sleep(2 * time.Second)
+		status, err := s.Runner.Status()
+		if err != nil {
+			return err
+		}
+		if !status.Running {
+			return fmt.Errorf("server is %s (exit code %d)", status.State, status.ExitCode)
+		}
+	}
+	return nil
+}
+
+// validateDataDir checks that dir looks like a server directory with a world in it
+func validateDataDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("directory is empty")
+	}
+
+	matches, err := filepath.Glob(filepath.Join(dir, "*", "level.dat"))
+	if err != nil {
+		return err
+	}
+	if len(matches) == 0 {
+		if ok, _ := exists(filepath.Join(dir, "level.dat")); !ok {
+			return fmt.Errorf("no level.dat found")
+		}
+	}
+	return nil
+}
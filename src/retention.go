@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// matches "<prefix>_YYYYMMDD_HHMMSS.<ext>"
+var backupNameRegex = regexp.MustCompile(`^([a-zA-Z0-9_-]+)_(\d{8}_\d{6})\.(zip|tar\.gz|tar\.zst|gz|bz2|7z|xz)$`)
+
+// RetentionPolicy is a grandfather-father-son rotation applied to every backup name prefix separately.
+// Zero values disable the corresponding rule, a backup is kept if any rule keeps it.
+type RetentionPolicy struct {
+	KeepLast    int `json:"keepLast"`    // newest N backups
+	KeepDaily   int `json:"keepDaily"`   // newest backup of each of the last N days that have backups
+	KeepWeekly  int `json:"keepWeekly"`  // newest backup of each of the last N ISO weeks that have backups
+	KeepMonthly int `json:"keepMonthly"` // newest backup of each of the last N months that have backups
+}
+
+type RetentionPlan struct {
+	Keep  []string `json:"keep"`
+	Prune []string `json:"prune"`
+}
+
+type RetentionReport struct {
+	Policy    RetentionPolicy   `json:"policy"`
+	DryRun    bool              `json:"dryRun"`
+	Local     RetentionPlan     `json:"local"`
+	Remote    *RetentionPlan    `json:"remote,omitempty"`
+	Snapshots *RetentionPlan    `json:"snapshots,omitempty"`
+	GC        *SnapshotGCReport `json:"gc,omitempty"`
+	Errors    []string          `json:"errors,omitempty"`
+}
+
+// RetentionPolicyFromEnv reads RETENTION_KEEP_LAST, RETENTION_KEEP_DAILY, RETENTION_KEEP_WEEKLY and RETENTION_KEEP_MONTHLY
+func RetentionPolicyFromEnv() (RetentionPolicy, error) {
+	var policy RetentionPolicy
+	for env, field := range map[string]*int{
+		"RETENTION_KEEP_LAST":    &policy.KeepLast,
+		"RETENTION_KEEP_DAILY":   &policy.KeepDaily,
+		"RETENTION_KEEP_WEEKLY":  &policy.KeepWeekly,
+		"RETENTION_KEEP_MONTHLY": &policy.KeepMonthly,
+	} {
+		v := os.Getenv(env)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return policy, fmt.Errorf("invalid %s %q", env, v)
+		}
+		*field = n
+	}
+	return policy, nil
+}
+
+func (p RetentionPolicy) Enabled() bool {
+	return p.KeepLast > 0 || p.KeepDaily > 0 || p.KeepWeekly > 0 || p.KeepMonthly > 0
+}
+
+// parseBackupName splits a backup file name (or snapshot name) into its prefix and creation time
+func parseBackupName(name string) (string, time.Time, bool) {
+	match := backupNameRegex.FindStringSubmatch(name)
+	if match == nil {
+		match = snapshotNameRegex.FindStringSubmatch(name)
+	}
+	if match == nil {
+		return "", time.Time{}, false
+	}
+	created, err := time.ParseInLocation("20060102_150405", match[2], time.Local)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	return match[1], created, true
+}
+
+// Plan decides which of the given backups are kept, names that don't follow the backup naming scheme are always kept
+func (p RetentionPolicy) Plan(names []string) RetentionPlan {
+	plan := RetentionPlan{Keep: []string{}, Prune: []string{}}
+	if !p.Enabled() {
+		plan.Keep = append(plan.Keep, names...)
+		return plan
+	}
+
+	type backup struct {
+		name    string
+		created time.Time
+	}
+	groups := map[string][]backup{}
+	for _, name := range names {
+		prefix, created, ok := parseBackupName(name)
+		if !ok {
+			plan.Keep = append(plan.Keep, name)
+			continue
+		}
+		groups[prefix] = append(groups[prefix], backup{name, created})
+	}
+
+	for _, backups := range groups {
+		sort.Slice(backups, func(i, k int) bool {
+			return backups[i].created.After(backups[k].created)
+		})
+
+		keep := map[string]bool{}
+		for i := 0; i < p.KeepLast && i < len(backups); i++ {
+			keep[backups[i].name] = true
+		}
+
+		periods := []struct {
+			count int
+			key   func(time.Time) string
+		}{
+			{p.KeepDaily, func(t time.Time) string { return t.Format("2006-01-02") }},
+			{p.KeepWeekly, func(t time.Time) string {
+				year, week := t.ISOWeek()
+				return fmt.Sprintf("%d-W%02d", year, week)
+			}},
+			{p.KeepMonthly, func(t time.Time) string { return t.Format("2006-01") }},
+		}
+		for _, period := range periods {
+			seen := map[string]bool{}
+			for _, b := range backups {
+				if len(seen) >= period.count {
+					break
+				}
+				key := period.key(b.created)
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+				keep[b.name] = true
+			}
+		}
+
+		for _, b := range backups {
+			if keep[b.name] {
+				plan.Keep = append(plan.Keep, b.name)
+			} else {
+				plan.Prune = append(plan.Prune, b.name)
+			}
+		}
+	}
+
+	sort.Strings(plan.Keep)
+	sort.Strings(plan.Prune)
+	return plan
+}
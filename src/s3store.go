@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3Store keeps backups in an S3-compatible bucket (AWS S3, MinIO, ...)
+type S3Store struct {
+	Bucket string
+	region string
+	client *minio.Client
+}
+
+func NewS3Store(endpoint, bucket, accessKey, secretKey, region string, useSSL bool) (*S3Store, error) {
+	if endpoint == "" || bucket == "" {
+		return nil, fmt.Errorf("S3_ENDPOINT and S3_BUCKET have to be set")
+	}
+
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(accessKey, secretKey, ""),
+		Secure: useSSL,
+		Region: region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create s3 client: %v", err)
+	}
+
+	return &S3Store{
+		Bucket: bucket,
+		region: region,
+		client: client,
+	}, nil
+}
+
+func (s *S3Store) Name() string {
+	return "s3://" + s.Bucket
+}
+
+func (s *S3Store) Prepare(ctx context.Context) error {
+	exists, err := s.client.BucketExists(ctx, s.Bucket)
+	if err != nil {
+		return fmt.Errorf("failed to check bucket %s: %v", s.Bucket, err)
+	}
+	if exists {
+		log.Printf("Bucket %v exists.\n", s.Bucket)
+		return nil
+	}
+
+	if err := s.client.MakeBucket(ctx, s.Bucket, minio.MakeBucketOptions{Region: s.region}); err != nil {
+		return fmt.Errorf("failed to create bucket: %v", err)
+	}
+	log.Printf("Bucket %v created.\n", s.Bucket)
+	return nil
+}
+
+func (s *S3Store) List(ctx context.Context) ([]string, error) {
+	objects := []string{}
+	for obj := range s.client.ListObjects(ctx, s.Bucket, minio.ListObjectsOptions{}) {
+		if obj.Err != nil {
+			return nil, fmt.Errorf("error listing objects: %v", obj.Err)
+		}
+		objects = append(objects, obj.Key)
+	}
+	return objects, nil
+}
+
+func (s *S3Store) Exists(ctx context.Context, name string) (bool, error) {
+	_, err := s.Stat(ctx, name)
+	if err == ErrObjectNotExist {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *S3Store) Upload(ctx context.Context, localPath string, progress TransferProgress) error {
+	objectName := filepath.Base(localPath)
+	opts := minio.PutObjectOptions{Progress: progressSink(progress)}
+	if _, err := s.client.FPutObject(ctx, s.Bucket, objectName, localPath, opts); err != nil {
+		return fmt.Errorf("failed to upload %s: %v", localPath, err)
+	}
+	log.Printf("File %v uploaded to bucket %v as %v", localPath, s.Bucket, objectName)
+	return nil
+}
+
+func (s *S3Store) Download(ctx context.Context, name string, localPath string, progress TransferProgress) error {
+	obj, err := s.client.GetObject(ctx, s.Bucket, name, minio.GetObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %v", name, err)
+	}
+	defer obj.Close()
+
+	file, err := os.Create(localPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(file, &progressReader{ctx: ctx, r: obj, progress: progress}); err != nil {
+		return fmt.Errorf("failed to download %s: %v", name, err)
+	}
+	return file.Close()
+}
+
+// progressSink is read by minio as bytes are uploaded
+type progressSink TransferProgress
+
+func (p progressSink) Read(b []byte) (int, error) {
+	TransferProgress(p).add(int64(len(b)))
+	return len(b), nil
+}
+
+func (s *S3Store) Open(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error) {
+	opts := minio.GetObjectOptions{}
+	if offset > 0 || length >= 0 {
+		end := int64(0) // 0 means to the end of the object
+		if length >= 0 {
+			end = offset + length - 1
+		}
+		if err := opts.SetRange(offset, end); err != nil {
+			return nil, err
+		}
+	}
+
+	obj, err := s.client.GetObject(ctx, s.Bucket, name, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %v", name, err)
+	}
+	return obj, nil
+}
+
+func (s *S3Store) Delete(ctx context.Context, name string) error {
+	if err := s.client.RemoveObject(ctx, s.Bucket, name, minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("failed to delete %s: %v", name, err)
+	}
+	return nil
+}
+
+func (s *S3Store) Stat(ctx context.Context, name string) (ObjectInfo, error) {
+	info, err := s.client.StatObject(ctx, s.Bucket, name, minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return ObjectInfo{}, ErrObjectNotExist
+		}
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{
+		Name:         name,
+		Size:         info.Size,
+		Updated:      info.LastModified,
+		Generation:   info.ETag,
+		MD5:          etagMD5(info),
+		StorageClass: info.StorageClass,
+	}, nil
+}
+
+// etagMD5 returns the MD5 of the content if the ETag is one. ETags of multipart uploads
+// ("<hash>-<parts>") and of objects encrypted with KMS or customer keys are not.
+func etagMD5(info minio.ObjectInfo) string {
+	if _, err := hex.DecodeString(info.ETag); err != nil || len(info.ETag) != 32 {
+		return ""
+	}
+	if strings.HasPrefix(info.Metadata.Get("X-Amz-Server-Side-Encryption"), "aws:kms") ||
+		info.Metadata.Get("X-Amz-Server-Side-Encryption-Customer-Algorithm") != "" {
+		return ""
+	}
+	return strings.ToLower(info.ETag)
+}
+
+// SetStorageClass copies the object onto itself with the new storage class, compose also copies
+// objects larger than the 5 GiB a single copy allows
+func (s *S3Store) SetStorageClass(ctx context.Context, name, class string) error {
+	if class == "" {
+		class = "STANDARD_IA"
+	}
+
+	dst := minio.CopyDestOptions{
+		Bucket:          s.Bucket,
+		Object:          name,
+		UserMetadata:    map[string]string{"X-Amz-Storage-Class": class},
+		ReplaceMetadata: true,
+	}
+	src := minio.CopySrcOptions{Bucket: s.Bucket, Object: name}
+	if _, err := s.client.ComposeObject(ctx, dst, src); err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return ErrObjectNotExist
+		}
+		return fmt.Errorf("failed to change storage class of %s: %v", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/minio/minio-go/v7"
+)
+
+func TestETagMD5(t *testing.T) {
+	const sum = "0cbc6611f5540bd0809a388dc95a615b"
+	tests := []struct {
+		name   string
+		etag   string
+		header http.Header
+		want   string
+	}{
+		{"single upload", sum, nil, sum},
+		{"upper case", "0CBC6611F5540BD0809A388DC95A615B", nil, sum},
+		{"multipart upload", "0cbc6611f5540bd0809a388dc95a615b-3", nil, ""},
+		{"not hex", "0cbc6611f5540bd0809a388dc95a615z", nil, ""},
+		{"sse-s3", sum, http.Header{"X-Amz-Server-Side-Encryption": {"AES256"}}, sum},
+		{"sse-kms", sum, http.Header{"X-Amz-Server-Side-Encryption": {"aws:kms"}}, ""},
+		{"sse-c", sum, http.Header{"X-Amz-Server-Side-Encryption-Customer-Algorithm": {"AES256"}}, ""},
+	}
+	for _, tt := range tests {
+		if got := etagMD5(minio.ObjectInfo{ETag: tt.etag, Metadata: tt.header}); got != tt.want {
+			t.Errorf("%s: etagMD5 = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
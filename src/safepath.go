@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var ErrUnsafePath = errors.New("unsafe path")
+
+// safeJoin joins an untrusted relative name (e.g. a zip entry) onto root, rejecting absolute
+// names, names escaping root with "..", and existing symlinks that resolve outside of root
+func safeJoin(root, name string) (string, error) {
+	if name == "" || filepath.IsAbs(name) || strings.HasPrefix(name, "/") || filepath.VolumeName(name) != "" {
+		return "", fmt.Errorf("%w: %q is absolute", ErrUnsafePath, name)
+	}
+
+	cleaned := filepath.Clean(filepath.FromSlash(name))
+	if cleaned == ".." || strings.HasPrefix(cleaned, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: %q escapes the target directory", ErrUnsafePath, name)
+	}
+
+	path := filepath.Join(root, cleaned)
+	if err := checkSymlinks(root, path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// checkSymlinks makes sure the existing part of path does not resolve outside of root
+func checkSymlinks(root, path string) error {
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	// find the deepest existing ancestor, the rest of the path will be created by us
+	existing := path
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			return nil
+		}
+		existing = parent
+	}
+
+	realPath, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return err
+	}
+	if !isWithin(realRoot, realPath) {
+		return fmt.Errorf("%w: %q resolves outside of %s", ErrUnsafePath, path, root)
+	}
+	return nil
+}
+
+func isWithin(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
+
+// backupPath validates a backup name coming from a request and returns its path in backupsDir
+func backupPath(name string) (string, error) {
+	_, _, validTime := parseBackupName(name)
+	if filepath.Base(name) != name || !backupNameRegex.MatchString(name) || !validTime {
+		return "", fmt.Errorf("%w: invalid backup name %q, expected <name>_<YYYYMMDD>_<HHMMSS>.<ext>", ErrUnsafePath, name)
+	}
+	return safeJoin(backupsDir, name)
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBackupPath(t *testing.T) {
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"world_20240101_120000.zip", true},
+		{"world-1_20240101_120000.tar.gz", true},
+		{"world_20240101_120000.tar.zst", true},
+		{"world_20240101_120000.gz", true},
+		{"world_20240101_120000.7z", true},
+		{"../a_20240101_000000.zip", false},
+		{"a/b_20240101_000000.zip", false},
+		{"/a_20240101_000000.zip", false},
+		{"..", false},
+		{"", false},
+		{"a_2024010_000000.zip", false},
+		{"a_20240101-000000.zip", false},
+		{"a_20241301_000000.zip", false},
+		{"a_20240132_000000.zip", false},
+		{"a_20240101_250000.zip", false},
+		{"a_20240101_000000.rar", false},
+		{"a_20240101_000000.zip.part", false},
+	}
+	for _, tt := range tests {
+		path, err := backupPath(tt.name)
+		if tt.ok {
+			if err != nil {
+				t.Errorf("backupPath(%q) failed: %v", tt.name, err)
+			} else if path != filepath.Join(backupsDir, tt.name) {
+				t.Errorf("backupPath(%q) = %q", tt.name, path)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrUnsafePath) {
+			t.Errorf("backupPath(%q) = %q, %v, want ErrUnsafePath", tt.name, path, err)
+		}
+	}
+}
+
+// testEntry is an archive entry, link makes it a symlink to link
+type testEntry struct {
+	name string
+	body string
+	link string
+}
+
+func writeTestZip(t *testing.T, path string, entries []testEntry) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	for _, e := range entries {
+		header := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+		body := e.body
+		if e.link != "" {
+			header.SetMode(os.ModeSymlink | 0777)
+			body = e.link
+		}
+		w, err := zw.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeTestTarGz(t *testing.T, path string, entries []testEntry) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		header := &tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.body)), Typeflag: tar.TypeReg}
+		if strings.HasSuffix(e.name, "/") {
+			header = &tar.Header{Name: e.name, Mode: 0755, Typeflag: tar.TypeDir}
+		} else if e.link != "" {
+			header = &tar.Header{Name: e.name, Mode: 0777, Linkname: e.link, Typeflag: tar.TypeSymlink}
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractArchiveRejectsUnsafeEntries(t *testing.T) {
+	formats := []struct {
+		ext   string
+		write func(*testing.T, string, []testEntry)
+	}{
+		{"zip", writeTestZip},
+		{"tar.gz", writeTestTarGz},
+	}
+
+	// outside is a sibling of the extraction directory, nothing may ever be written there
+	tests := []struct {
+		name    string
+		entries func(outside string) []testEntry
+		paths   []string
+		setup   func(t *testing.T, target, outside string) // prepares the target directory
+	}{
+		{
+			name:    "parent directory",
+			entries: func(string) []testEntry { return []testEntry{{name: "../x", body: "escaped"}} },
+		},
+		{
+			name:    "nested parent directory",
+			entries: func(string) []testEntry { return []testEntry{{name: "world/../../../outside/x", body: "escaped"}} },
+		},
+		{
+			name:    "parent directory with paths",
+			entries: func(string) []testEntry { return []testEntry{{name: "../x", body: "escaped"}} },
+			paths:   []string{"x"},
+		},
+		{
+			name: "absolute path",
+			entries: func(outside string) []testEntry {
+				return []testEntry{{name: filepath.ToSlash(filepath.Join(outside, "abs")), body: "escaped"}}
+			},
+		},
+		{
+			name: "symlink entry",
+			entries: func(outside string) []testEntry {
+				return []testEntry{{name: "link", link: outside}, {name: "link/x", body: "escaped"}}
+			},
+		},
+		{
+			name:    "relative symlink entry",
+			entries: func(string) []testEntry { return []testEntry{{name: "world/link", link: "../../outside"}} },
+		},
+		{
+			name:    "symlinked parent directory",
+			entries: func(string) []testEntry { return []testEntry{{name: "world/x", body: "escaped"}} },
+			setup: func(t *testing.T, target, outside string) {
+				if err := os.MkdirAll(target, 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Symlink(outside, filepath.Join(target, "world")); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+	}
+
+	for _, format := range formats {
+		for _, tt := range tests {
+			t.Run(format.ext+"/"+tt.name, func(t *testing.T) {
+				dir := t.TempDir()
+				outside := filepath.Join(dir, "outside")
+				work := filepath.Join(dir, "work")
+				target := filepath.Join(work, "target")
+				if err := os.MkdirAll(outside, 0755); err != nil {
+					t.Fatal(err)
+				}
+				if tt.setup != nil {
+					tt.setup(t, target, outside)
+				}
+
+				archive := filepath.Join(dir, "evil_20240101_000000."+format.ext)
+				format.write(t, archive, tt.entries(outside))
+
+				err := extractArchivePaths(archive, target, tt.paths)
+				if !errors.Is(err, ErrUnsafePath) {
+					t.Fatalf("extraction returned %v, want ErrUnsafePath", err)
+				}
+
+				if entries, _ := os.ReadDir(outside); len(entries) != 0 {
+					t.Errorf("%d entries written to %s", len(entries), outside)
+				}
+				entries, err := os.ReadDir(work)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, e := range entries {
+					if e.Name() != "target" {
+						t.Errorf("%s written next to the target directory", e.Name())
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestExtractArchive(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "world_20240101_000000.tar.gz")
+	writeTestTarGz(t, archive, []testEntry{
+		{name: "./"},
+		{name: "world/level.dat", body: "level"},
+		{name: "server.properties", body: "motd=hi"},
+	})
+
+	target := filepath.Join(dir, "target")
+	if err := extractArchivePaths(archive, target, []string{"world"}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(target, "world", "level.dat"))
+	if err != nil || string(data) != "level" {
+		t.Fatalf("level.dat = %q, %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(target, "server.properties")); !os.IsNotExist(err) {
+		t.Errorf("server.properties extracted although it was not selected: %v", err)
+	}
+}
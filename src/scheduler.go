@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// BackupSchedule describes a recurring backup, Spec is a standard cron expression
+// (e.g. "0 * * * *" or "0 4 * * *") or a descriptor like "@hourly"
+type BackupSchedule struct {
+	ID            string        `json:"id"`
+	Name          string        `json:"name"`
+	Spec          string        `json:"spec"`
+	Prefix        string        `json:"prefix"`
+	Format        ArchiveFormat `json:"format,omitempty"`      // server's default format if empty
+	Incremental   bool          `json:"incremental,omitempty"` // take a snapshot instead of an archive
+	SkipIfStopped bool          `json:"skipIfStopped"`
+	Paused        bool          `json:"paused"`
+	Created       time.Time     `json:"created"`
+	LastRun       time.Time     `json:"lastRun,omitempty"`
+	LastResult    string        `json:"lastResult,omitempty"`
+	LastError     string        `json:"lastError,omitempty"`
+	NextRun       time.Time     `json:"nextRun,omitempty"`
+}
+
+// ScheduleRunFunc performs the backup for a schedule, it returns the created backup name
+// or an empty name if the run was skipped
+type ScheduleRunFunc func(schedule BackupSchedule) (string, error)
+
+// Scheduler runs backup schedules in-process and persists them to a JSON file
+type Scheduler struct {
+	mu        sync.Mutex
+	cron      *cron.Cron
+	path      string
+	run       ScheduleRunFunc
+	schedules map[string]*BackupSchedule
+	entries   map[string]cron.EntryID
+}
+
+func NewScheduler(path string, run ScheduleRunFunc) *Scheduler {
+	return &Scheduler{
+		cron:      cron.New(),
+		path:      path,
+		run:       run,
+		schedules: map[string]*BackupSchedule{},
+		entries:   map[string]cron.EntryID{},
+	}
+}
+
+// Start loads persisted schedules and starts the cron runner
+func (sc *Scheduler) Start() error {
+	var schedules []*BackupSchedule
+	if err := readJSONFile(sc.path, &schedules); err != nil {
+		return fmt.Errorf("failed to load schedules from %s: %v", sc.path, err)
+	}
+
+	sc.mu.Lock()
+	for _, schedule := range schedules {
+		sc.schedules[schedule.ID] = schedule
+		if schedule.Paused {
+			continue
+		}
+		if err := sc.register(schedule); err != nil {
+			log.Printf("Error registering schedule %s: %v", schedule.Name, err)
+		}
+	}
+	sc.mu.Unlock()
+
+	sc.cron.Start()
+	log.Printf("scheduler started with %d schedules\n", len(schedules))
+	return nil
+}
+
+func (sc *Scheduler) Stop() {
+	<-sc.cron.Stop().Done()
+}
+
+// List returns all schedules ordered by creation time
+func (sc *Scheduler) List() []BackupSchedule {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	schedules := make([]BackupSchedule, 0, len(sc.schedules))
+	for id, schedule := range sc.schedules {
+		s := *schedule
+		if entryID, ok := sc.entries[id]; ok {
+			s.NextRun = sc.cron.Entry(entryID).Next
+		}
+		schedules = append(schedules, s)
+	}
+	sort.Slice(schedules, func(i, k int) bool {
+		return schedules[i].Created.Before(schedules[k].Created)
+	})
+	return schedules
+}
+
+func (sc *Scheduler) Add(schedule BackupSchedule) (BackupSchedule, error) {
+	if _, err := cron.ParseStandard(schedule.Spec); err != nil {
+		return schedule, fmt.Errorf("invalid cron expression %q: %v", schedule.Spec, err)
+	}
+	if schedule.Prefix == "" {
+		schedule.Prefix = "scheduled"
+	}
+	if !backupPrefixRegex.MatchString(schedule.Prefix) {
+		return schedule, fmt.Errorf("invalid backup name prefix %q", schedule.Prefix)
+	}
+	if schedule.Format != "" {
+		if _, err := ParseArchiveFormat(string(schedule.Format)); err != nil {
+			return schedule, err
+		}
+	}
+	if schedule.Name == "" {
+		schedule.Name = schedule.Prefix
+	}
+	schedule.ID = newJobID()
+	schedule.Created = time.Now()
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	s := &schedule
+	sc.schedules[s.ID] = s
+	if !s.Paused {
+		if err := sc.register(s); err != nil {
+			delete(sc.schedules, s.ID)
+			return schedule, err
+		}
+	}
+
+	log.Printf("schedule %s (%s) added\n", s.Name, s.Spec)
+	return schedule, sc.save()
+}
+
+// SetPaused pauses or resumes a schedule
+func (sc *Scheduler) SetPaused(id string, paused bool) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	schedule, ok := sc.schedules[id]
+	if !ok {
+		return fmt.Errorf("schedule %s not found", id)
+	}
+	if schedule.Paused == paused {
+		return nil
+	}
+
+	schedule.Paused = paused
+	if paused {
+		sc.unregister(id)
+	} else if err := sc.register(schedule); err != nil {
+		return err
+	}
+
+	log.Printf("schedule %s paused: %v\n", schedule.Name, paused)
+	return sc.save()
+}
+
+func (sc *Scheduler) Delete(id string) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	schedule, ok := sc.schedules[id]
+	if !ok {
+		return fmt.Errorf("schedule %s not found", id)
+	}
+
+	sc.unregister(id)
+	delete(sc.schedules, id)
+
+	log.Printf("schedule %s deleted\n", schedule.Name)
+	return sc.save()
+}
+
+// register adds the schedule to the cron runner, caller must hold sc.mu
+func (sc *Scheduler) register(schedule *BackupSchedule) error {
+	id := schedule.ID
+	entryID, err := sc.cron.AddFunc(schedule.Spec, func() {
+		sc.execute(id)
+	})
+	if err != nil {
+		return fmt.Errorf("invalid cron expression %q: %v", schedule.Spec, err)
+	}
+	sc.entries[id] = entryID
+	return nil
+}
+
+// unregister removes the schedule from the cron runner, caller must hold sc.mu
+func (sc *Scheduler) unregister(id string) {
+	if entryID, ok := sc.entries[id]; ok {
+		sc.cron.Remove(entryID)
+		delete(sc.entries, id)
+	}
+}
+
+func (sc *Scheduler) execute(id string) {
+	sc.mu.Lock()
+	schedule, ok := sc.schedules[id]
+	if !ok || schedule.Paused {
+		sc.mu.Unlock()
+		return
+	}
+	snapshot := *schedule
+	sc.mu.Unlock()
+
+	log.Printf("running scheduled backup %s\n", snapshot.Name)
+	name, err := sc.run(snapshot)
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	schedule.LastRun = time.Now()
+	schedule.LastError = ""
+	switch {
+	case err != nil:
+		schedule.LastResult = "failed"
+		schedule.LastError = err.Error()
+		log.Printf("scheduled backup %s failed: %v\n", snapshot.Name, err)
+	case name == "":
+		schedule.LastResult = "skipped"
+	default:
+		schedule.LastResult = name
+	}
+
+	if err := sc.save(); err != nil {
+		log.Printf("Error saving schedules: %v", err)
+	}
+}
+
+// save persists the schedules, caller must hold sc.mu
+func (sc *Scheduler) save() error {
+	schedules := make([]*BackupSchedule, 0, len(sc.schedules))
+	for _, schedule := range sc.schedules {
+		schedules = append(schedules, schedule)
+	}
+	sort.Slice(schedules, func(i, k int) bool {
+		return schedules[i].Created.Before(schedules[k].Created)
+	})
+	return writeJSONFile(sc.path, schedules)
+}
@@ -0,0 +1,608 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	snapshotsDir = "backups/snapshots" // one JSON manifest per snapshot and a summary without the file list
+	chunksDir    = "backups/chunks"    // zstd compressed chunks named by the SHA-256 of their content
+
+	// region files are rewritten in place, fixed size chunks keep unchanged parts deduplicated
+	snapshotChunkSize = 1 << 20
+)
+
+var snapshotNameRegex = regexp.MustCompile(`^([a-zA-Z0-9_-]+)_(\d{8}_\d{6})$`)
+
+// SnapshotFile is a file of a snapshot, its content is the concatenation of its chunks
+type SnapshotFile struct {
+	Path    string      `json:"path"` // slash separated, relative to mcdata
+	Mode    os.FileMode `json:"mode"`
+	Size    int64       `json:"size"`
+	ModTime time.Time   `json:"modTime"`
+	Chunks  []string    `json:"chunks"`
+}
+
+// Snapshot is a point-in-time copy of mcdata kept in the chunk store
+type Snapshot struct {
+	Name             string         `json:"name"`
+	Created          time.Time      `json:"created"`
+	TriggeredBy      string         `json:"triggeredBy,omitempty"`
+	ServerRunning    bool           `json:"serverRunning"`
+	Consistent       bool           `json:"consistent"`
+	Warnings         []string       `json:"warnings,omitempty"`
+	MinecraftVersion string         `json:"minecraftVersion,omitempty"`
+	Seed             string         `json:"seed,omitempty"`
+	FileCount        int            `json:"fileCount"`
+	Size             int64          `json:"size"`      // total size of all files
+	NewChunks        int            `json:"newChunks"` // chunks that were not stored by an earlier snapshot
+	NewBytes         int64          `json:"newBytes"`  // compressed size of the new chunks
+	Dirs             []string       `json:"dirs,omitempty"`
+	Files            []SnapshotFile `json:"files,omitempty"`
+}
+
+// SnapshotGCReport lists what a garbage collection removed (or would remove)
+type SnapshotGCReport struct {
+	DryRun     bool  `json:"dryRun"`
+	Snapshots  int   `json:"snapshots"`
+	Referenced int   `json:"referenced"`
+	Removed    int   `json:"removed"`
+	FreedBytes int64 `json:"freedBytes"`
+}
+
+// SnapshotStore keeps incremental, deduplicated snapshots of a directory
+type SnapshotStore struct {
+	mu      sync.Mutex // creating snapshots and collecting garbage must not overlap
+	dir     string
+	chunks  string
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+}
+
+func NewSnapshotStore(dir, chunks string) (*SnapshotStore, error) {
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		return nil, err
+	}
+	decoder, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, err
+	}
+	return &SnapshotStore{dir: dir, chunks: chunks, encoder: encoder, decoder: decoder}, nil
+}
+
+func (st *SnapshotStore) manifestPath(name string) (string, error) {
+	if !snapshotNameRegex.MatchString(name) {
+		return "", fmt.Errorf("%w: invalid snapshot name %q", ErrUnsafePath, name)
+	}
+	return filepath.Join(st.dir, name+".json"), nil
+}
+
+// Exists reports whether a snapshot of that name was taken
+func (st *SnapshotStore) Exists(name string) (bool, error) {
+	path, err := st.manifestPath(name)
+	if err != nil {
+		return false, err
+	}
+	return exists(path)
+}
+
+// summaryPath is the manifest without the file list, which is all listing snapshots needs
+func (st *SnapshotStore) summaryPath(name string) string {
+	return filepath.Join(st.dir, name+".meta.json")
+}
+
+// write saves the manifest and then its summary
+func (st *SnapshotStore) write(path string, snapshot Snapshot) error {
+	if err := writeJSONFile(path, snapshot); err != nil {
+		return err
+	}
+	snapshot.Dirs = nil
+	snapshot.Files = nil
+	return writeJSONFile(st.summaryPath(snapshot.Name), snapshot)
+}
+
+func (st *SnapshotStore) chunkPath(hash string) string {
+	return filepath.Join(st.chunks, hash[:2], hash)
+}
+
+// Create stores a snapshot of source. Files with the same size and modification time as in the
+// previous snapshot are not read again, other files are split into chunks and only chunks that
+// are not in the store yet are written.
+func (st *SnapshotStore) Create(source string, snapshot Snapshot) (Snapshot, error) {
+	path, err := st.manifestPath(snapshot.Name)
+	if err != nil {
+		return snapshot, err
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if _, err := os.Stat(path); err == nil {
+		return snapshot, fmt.Errorf("snapshot %s already exists", snapshot.Name)
+	}
+	if err := os.MkdirAll(st.dir, 0755); err != nil {
+		return snapshot, err
+	}
+	if err := os.MkdirAll(st.chunks, 0755); err != nil {
+		return snapshot, err
+	}
+
+	previous := map[string]SnapshotFile{}
+	if latest, err := st.latest(); err == nil {
+		for _, file := range latest.Files {
+			previous[file.Path] = file
+		}
+	} else if !os.IsNotExist(err) {
+		log.Printf("Error reading latest snapshot, not reusing unchanged files: %v", err)
+	}
+
+	err = filepath.Walk(source, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(source, p)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		name := filepath.ToSlash(rel)
+
+		if info.IsDir() {
+			snapshot.Dirs = append(snapshot.Dirs, name)
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			log.Printf("skipping %s, not a regular file\n", p)
+			return nil
+		}
+
+		file := SnapshotFile{
+			Path:    name,
+			Mode:    info.Mode().Perm(),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		}
+		if prev, ok := previous[name]; ok && prev.Size == file.Size && prev.ModTime.Equal(file.ModTime) && st.hasChunks(prev.Chunks) {
+			file.Chunks = prev.Chunks
+		} else {
+			chunks, newChunks, newBytes, err := st.storeFile(p)
+			if err != nil {
+				return fmt.Errorf("failed to store %s: %v", name, err)
+			}
+			file.Chunks = chunks
+			snapshot.NewChunks += newChunks
+			snapshot.NewBytes += newBytes
+		}
+
+		snapshot.Files = append(snapshot.Files, file)
+		snapshot.FileCount++
+		snapshot.Size += file.Size
+		return nil
+	})
+	if err != nil {
+		return snapshot, err
+	}
+
+	if err := st.write(path, snapshot); err != nil {
+		return snapshot, err
+	}
+	return snapshot, nil
+}
+
+// Update rewrites the metadata of an existing snapshot
+func (st *SnapshotStore) Update(snapshot Snapshot) error {
+	path, err := st.manifestPath(snapshot.Name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	return st.write(path, snapshot)
+}
+
+// storeFile splits a file into chunks and writes the ones missing in the store
+func (st *SnapshotStore) storeFile(path string) ([]string, int, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer f.Close()
+
+	var chunks []string
+	newChunks := 0
+	var newBytes int64
+	buf := make([]byte, snapshotChunkSize)
+	for {
+		n, err := io.ReadFull(f, buf)
+		if n > 0 {
+			sum := sha256.Sum256(buf[:n])
+			hash := hex.EncodeToString(sum[:])
+			written, err := st.writeChunk(hash, buf[:n])
+			if err != nil {
+				return nil, 0, 0, err
+			}
+			if written > 0 {
+				newChunks++
+				newBytes += written
+			}
+			chunks = append(chunks, hash)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, 0, err
+		}
+	}
+	return chunks, newChunks, newBytes, nil
+}
+
+// writeChunk stores a chunk unless it exists already, it returns the number of bytes written
+func (st *SnapshotStore) writeChunk(hash string, data []byte) (int64, error) {
+	path := st.chunkPath(hash)
+	if _, err := os.Stat(path); err == nil {
+		return 0, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return 0, err
+	}
+
+	compressed := st.encoder.EncodeAll(data, nil)
+	tmp, err := os.CreateTemp(filepath.Dir(path), hash+".tmp*")
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tmp.Write(compressed); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return 0, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return 0, err
+	}
+	return int64(len(compressed)), nil
+}
+
+// readChunk returns the content of a chunk and checks it against its hash
+func (st *SnapshotStore) readChunk(hash string) ([]byte, error) {
+	compressed, err := os.ReadFile(st.chunkPath(hash))
+	if err != nil {
+		return nil, err
+	}
+	data, err := st.decoder.DecodeAll(compressed, nil)
+	if err != nil {
+		return nil, fmt.Errorf("chunk %s is corrupt: %v", hash, err)
+	}
+	if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != hash {
+		return nil, fmt.Errorf("chunk %s is corrupt: checksum mismatch", hash)
+	}
+	return data, nil
+}
+
+func (st *SnapshotStore) hasChunks(chunks []string) bool {
+	for _, hash := range chunks {
+		if _, err := os.Stat(st.chunkPath(hash)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Get reads a snapshot including its file list
+func (st *SnapshotStore) Get(name string) (Snapshot, error) {
+	var snapshot Snapshot
+	path, err := st.manifestPath(name)
+	if err != nil {
+		return snapshot, err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return snapshot, err
+	}
+	err = readJSONFile(path, &snapshot)
+	return snapshot, err
+}
+
+// names reads the names of all snapshot manifests from the directory
+func (st *SnapshotStore) names() ([]string, error) {
+	entries, err := os.ReadDir(st.dir)
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".json")
+		if ok && snapshotNameRegex.MatchString(name) {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// summary reads the summary of a snapshot, snapshots taken before summaries existed get one
+// written from the full manifest
+func (st *SnapshotStore) summary(name string) (Snapshot, error) {
+	var snapshot Snapshot
+	if err := readJSONFile(st.summaryPath(name), &snapshot); err != nil {
+		return snapshot, err
+	}
+	if snapshot.Name == name {
+		return snapshot, nil
+	}
+
+	snapshot, err := st.Get(name)
+	if err != nil {
+		return snapshot, err
+	}
+	path, _ := st.manifestPath(name)
+	if err := st.write(path, snapshot); err != nil {
+		log.Printf("Error saving summary of snapshot %s: %v", name, err)
+	}
+	snapshot.Dirs = nil
+	snapshot.Files = nil
+	return snapshot, nil
+}
+
+// List returns all snapshots without their file lists, newest first. Snapshots that cannot be
+// read are logged and left out.
+func (st *SnapshotStore) List() ([]Snapshot, error) {
+	names, err := st.names()
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := []Snapshot{}
+	for _, name := range names {
+		snapshot, err := st.summary(name)
+		if err != nil {
+			log.Printf("Error reading snapshot %s: %v", name, err)
+			continue
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Created.After(snapshots[j].Created)
+	})
+	return snapshots, nil
+}
+
+// Names returns the names of all snapshots
+func (st *SnapshotStore) Names() ([]string, error) {
+	snapshots, err := st.List()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		names = append(names, snapshot.Name)
+	}
+	return names, nil
+}
+
+// latest returns the newest snapshot, os.ErrNotExist if there is none
+func (st *SnapshotStore) latest() (Snapshot, error) {
+	snapshots, err := st.List()
+	if err != nil {
+		return Snapshot{}, err
+	}
+	if len(snapshots) == 0 {
+		return Snapshot{}, os.ErrNotExist
+	}
+	return st.Get(snapshots[0].Name)
+}
+
+// Delete removes a snapshot, its chunks are removed by the next garbage collection
+func (st *SnapshotStore) Delete(name string) error {
+	path, err := st.manifestPath(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	if err := os.Remove(st.summaryPath(name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	log.Printf("snapshot %s deleted\n", name)
+	return nil
+}
+
+// Restore writes the files of a snapshot into target, with paths set only those files or
+// directories are restored
+func (st *SnapshotStore) Restore(name, target string, paths []string) error {
+	snapshot, err := st.Get(name)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return err
+	}
+	for _, dir := range snapshot.Dirs {
+		if len(paths) > 0 && !underAny(dir, paths) {
+			continue
+		}
+		path, err := safeJoin(target, dir)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(path, 0755); err != nil {
+			return err
+		}
+	}
+
+	for _, file := range snapshot.Files {
+		if len(paths) > 0 && !underAny(file.Path, paths) {
+			continue
+		}
+		path, err := safeJoin(target, file.Path)
+		if err != nil {
+			return err
+		}
+		if err := st.restoreFile(file, path); err != nil {
+			return fmt.Errorf("failed to restore %s: %v", file.Path, err)
+		}
+	}
+	return nil
+}
+
+func (st *SnapshotStore) restoreFile(file SnapshotFile, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		return fmt.Errorf("%w: %q is a symlink", ErrUnsafePath, path)
+	}
+
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, file.Mode)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	for _, hash := range file.Chunks {
+		data, err := st.readChunk(hash)
+		if err != nil {
+			return err
+		}
+		if _, err := out.Write(data); err != nil {
+			return err
+		}
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	// keep the modification time so the next snapshot can reuse the chunk list
+	return os.Chtimes(path, file.ModTime, file.ModTime)
+}
+
+// Contents returns the direct children of prefix ("" for the top level) in a snapshot
+func (st *SnapshotStore) Contents(name, prefix string) ([]ArchiveEntry, error) {
+	snapshot, err := st.Get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix = strings.Trim(prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	entries := map[string]*ArchiveEntry{}
+	add := func(name string, dir bool, size int64) {
+		if !strings.HasPrefix(name, prefix) {
+			return
+		}
+		child, _, nested := strings.Cut(strings.TrimPrefix(name, prefix), "/")
+		entry, ok := entries[child]
+		if !ok {
+			entry = &ArchiveEntry{Path: prefix + child}
+			entries[child] = entry
+		}
+		if dir || nested {
+			entry.Dir = true
+		}
+		if !dir {
+			entry.Size += size
+			entry.Files++
+		}
+	}
+	for _, dir := range snapshot.Dirs {
+		add(dir, true, 0)
+	}
+	for _, file := range snapshot.Files {
+		add(file.Path, false, file.Size)
+	}
+
+	result := make([]ArchiveEntry, 0, len(entries))
+	for _, entry := range entries {
+		result = append(result, *entry)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Path < result[j].Path
+	})
+	return result, nil
+}
+
+// GC removes chunks that are not referenced by any snapshot. It reads every manifest itself
+// instead of listing snapshots, which skips unreadable ones.
+func (st *SnapshotStore) GC(dryRun bool) (SnapshotGCReport, error) {
+	report := SnapshotGCReport{DryRun: dryRun}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	names, err := st.names()
+	if err != nil {
+		return report, err
+	}
+	referenced := map[string]bool{}
+	for _, name := range names {
+		snapshot, err := st.Get(name)
+		if err != nil {
+			// never collect chunks of a snapshot that could not be read
+			return report, fmt.Errorf("failed to read snapshot %s: %v", name, err)
+		}
+		for _, file := range snapshot.Files {
+			for _, hash := range file.Chunks {
+				referenced[hash] = true
+			}
+		}
+	}
+	report.Snapshots = len(names)
+	report.Referenced = len(referenced)
+
+	err = filepath.Walk(st.chunks, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || referenced[info.Name()] {
+			return nil
+		}
+
+		report.Removed++
+		report.FreedBytes += info.Size()
+		if dryRun {
+			return nil
+		}
+		return os.Remove(path)
+	})
+	if err != nil {
+		return report, err
+	}
+
+	if !dryRun {
+		log.Printf("snapshot gc removed %d chunks (%d bytes)\n", report.Removed, report.FreedBytes)
+	}
+	return report, nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestSnapshotStore(t *testing.T) (*SnapshotStore, string) {
+	t.Helper()
+	dir := t.TempDir()
+	st, err := NewSnapshotStore(filepath.Join(dir, "snapshots"), filepath.Join(dir, "chunks"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source := filepath.Join(dir, "mcdata")
+	if err := os.MkdirAll(filepath.Join(source, "world"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(source, "world", "level.dat"), []byte("level"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return st, source
+}
+
+func TestSnapshotListUsesSummaries(t *testing.T) {
+	st, source := newTestSnapshotStore(t)
+	name := "world_20240101_000000"
+	if _, err := st.Create(source, Snapshot{Name: name, Created: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(st.summaryPath(name)); err != nil {
+		t.Fatalf("no summary written: %v", err)
+	}
+
+	// snapshots taken before summaries existed get one
+	if err := os.Remove(st.summaryPath(name)); err != nil {
+		t.Fatal(err)
+	}
+	snapshots, err := st.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 1 || snapshots[0].Name != name || snapshots[0].FileCount != 1 || snapshots[0].Files != nil {
+		t.Fatalf("List = %+v", snapshots)
+	}
+	if _, err := os.Stat(st.summaryPath(name)); err != nil {
+		t.Errorf("summary not written while listing: %v", err)
+	}
+
+	if err := st.Delete(name); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(st.summaryPath(name)); !os.IsNotExist(err) {
+		t.Errorf("summary left after Delete: %v", err)
+	}
+}
+
+func TestSnapshotGCKeepsChunksOfUnreadableSnapshots(t *testing.T) {
+	st, source := newTestSnapshotStore(t)
+	if _, err := st.Create(source, Snapshot{Name: "world_20240101_000000", Created: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a half-written manifest is skipped by List but must stop the collection
+	broken := filepath.Join(st.dir, "world_20240102_000000.json")
+	if err := os.WriteFile(broken, []byte(`{"name": "world_20240102_000000", "files": [`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(st.dir, "world_20240101_000000.json")); err != nil {
+		t.Fatal(err)
+	}
+	if snapshots, err := st.List(); err != nil || len(snapshots) != 0 {
+		t.Fatalf("List = %+v, %v", snapshots, err)
+	}
+
+	if _, err := st.GC(false); err == nil {
+		t.Fatal("GC ignored an unreadable snapshot")
+	}
+	chunks := 0
+	filepath.Walk(st.chunks, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			chunks++
+		}
+		return nil
+	})
+	if chunks != 1 {
+		t.Errorf("%d chunks left, want 1", chunks)
+	}
+
+	if err := os.Remove(broken); err != nil {
+		t.Fatal(err)
+	}
+	report, err := st.GC(false)
+	if err != nil || report.Removed != 1 {
+		t.Errorf("GC without snapshots = %+v, %v", report, err)
+	}
+}
+
+func TestSnapshotCreateRefusesExistingName(t *testing.T) {
+	st, source := newTestSnapshotStore(t)
+	name := "world_20240101_000000"
+	if _, err := st.Create(source, Snapshot{Name: name, Created: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := st.Exists(name); !ok || err != nil {
+		t.Fatalf("Exists = %v, %v", ok, err)
+	}
+	if _, err := st.Create(source, Snapshot{Name: name, Created: time.Now(), TriggeredBy: "second"}); err == nil {
+		t.Fatal("second snapshot with the same name was created")
+	}
+	if snapshot, err := st.Get(name); err != nil || snapshot.TriggeredBy != "" {
+		t.Errorf("first snapshot = %+v, %v", snapshot, err)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+var ErrObjectNotExist = errors.New("object does not exist")
+
+var ErrNoStore = errors.New("cloud storage is not configured")
+
+var ErrNotSupported = errors.New("not supported by this backup store")
+
+// ObjectInfo describes a backup kept in a BackupStore
+type ObjectInfo struct {
+	Name         string    `json:"name"`
+	Size         int64     `json:"size"`
+	Updated      time.Time `json:"updated"`
+	Generation   string    `json:"generation"`             // changes whenever the object is rewritten (GCS generation, S3 ETag, file mtime)
+	MD5          string    `json:"md5,omitempty"`          // hex MD5 of the content if the store knows it
+	CRC32C       string    `json:"crc32c,omitempty"`       // hex CRC32C (Castagnoli) of the content if the store knows it
+	StorageClass string    `json:"storageClass,omitempty"` // e.g. STANDARD or COLDLINE, empty if the store has none
+}
+
+// BackupStore is a remote location backups are synchronized with
+type BackupStore interface {
+	// Name describes the store for logs and the UI, e.g. "gs://bucket"
+	Name() string
+	// Prepare makes sure the store is usable (e.g. creates the bucket)
+	Prepare(ctx context.Context) error
+	List(ctx context.Context) ([]string, error)
+	Exists(ctx context.Context, name string) (bool, error)
+	// Upload stores the local file under its base name, progress (may be nil) is told about sent bytes
+	Upload(ctx context.Context, localPath string, progress TransferProgress) error
+	// Download writes the object to localPath, progress (may be nil) is told about received bytes
+	Download(ctx context.Context, name string, localPath string, progress TransferProgress) error
+	// Open reads length bytes of the object starting at offset, length -1 reads to the end
+	Open(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error)
+	Delete(ctx context.Context, name string) error
+	// Stat returns ErrObjectNotExist if there is no such object
+	Stat(ctx context.Context, name string) (ObjectInfo, error)
+}
+
+// CRC32CUploadStore is implemented by stores that check uploads against a CRC32C, the caller passes
+// one it already computed so the file is not read an extra time
+type CRC32CUploadStore interface {
+	// UploadCRC32C is Upload with the CRC32C (Castagnoli) of the local file
+	UploadCRC32C(ctx context.Context, localPath string, crc32c uint32, progress TransferProgress) error
+}
+
+// StorageClassStore is implemented by stores that can move objects to a cheaper storage class
+type StorageClassStore interface {
+	// SetStorageClass rewrites the object with another storage class, an empty class selects
+	// the store's cold class. The object may get a new generation.
+	SetStorageClass(ctx context.Context, name, class string) error
+}
+
+// NewBackupStoreFromEnv selects the storage backend with BACKUP_STORE (gcs, s3 or local).
+// When BACKUP_STORE is not set, GCS is used if BACKUPS_BUCKET and PROJECT_ID are set,
+// otherwise cloud backups are disabled and nil is returned.
+func NewBackupStoreFromEnv() (BackupStore, error) {
+	kind := os.Getenv("BACKUP_STORE")
+	if kind == "" && os.Getenv("BACKUPS_BUCKET") != "" && os.Getenv("PROJECT_ID") != "" {
+		kind = "gcs"
+	}
+
+	switch kind {
+	case "":
+		return nil, nil
+	case "gcs":
+		if host := os.Getenv("STORAGE_EMULATOR_HOST"); host != "" {
+			log.Printf("using the GCS emulator at %s, requests are not authenticated\n", host)
+		}
+		return NewBucket(os.Getenv("BACKUPS_BUCKET"), os.Getenv("PROJECT_ID"))
+	case "s3":
+		useSSL := true
+		if v := os.Getenv("S3_USE_SSL"); v != "" {
+			parsed, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid S3_USE_SSL %q: %v", v, err)
+			}
+			useSSL = parsed
+		}
+		return NewS3Store(
+			os.Getenv("S3_ENDPOINT"),
+			os.Getenv("S3_BUCKET"),
+			os.Getenv("S3_ACCESS_KEY"),
+			os.Getenv("S3_SECRET_KEY"),
+			os.Getenv("S3_REGION"),
+			useSSL,
+		)
+	case "local":
+		return NewLocalStore(os.Getenv("BACKUP_STORE_DIR"))
+	default:
+		return nil, fmt.Errorf("unknown BACKUP_STORE %q, use gcs, s3 or local", kind)
+	}
+}
+
+// TransferProgress is called with the number of bytes transferred since the previous call
+type TransferProgress func(n int64)
+
+// add reports n bytes, a negative n takes back bytes of a failed attempt
+func (p TransferProgress) add(n int64) {
+	if p != nil && n != 0 {
+		p(n)
+	}
+}
+
+// progressReader reports what is read through it and stops once ctx is done
+type progressReader struct {
+	ctx      context.Context
+	r        io.Reader
+	progress TransferProgress
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	if err := p.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := p.r.Read(b)
+	p.progress.add(int64(n))
+	return n, err
+}
+
+// objectReadSeeker reads an object of a BackupStore as an io.ReadSeeker (for http.ServeContent),
+// a ranged read is opened lazily at the current offset
+type objectReadSeeker struct {
+	ctx    context.Context
+	store  BackupStore
+	name   string
+	size   int64
+	offset int64
+	reader io.ReadCloser
+}
+
+func newObjectReadSeeker(ctx context.Context, store BackupStore, info ObjectInfo) *objectReadSeeker {
+	return &objectReadSeeker{ctx: ctx, store: store, name: info.Name, size: info.Size}
+}
+
+func (o *objectReadSeeker) Read(p []byte) (int, error) {
+	if o.offset >= o.size {
+		return 0, io.EOF
+	}
+	if o.reader == nil {
+		reader, err := o.store.Open(o.ctx, o.name, o.offset, -1)
+		if err != nil {
+			return 0, err
+		}
+		o.reader = reader
+	}
+	n, err := o.reader.Read(p)
+	o.offset += int64(n)
+	return n, err
+}
+
+func (o *objectReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	var target int64
+	switch whence {
+	case io.SeekStart:
+		target = offset
+	case io.SeekCurrent:
+		target = o.offset + offset
+	case io.SeekEnd:
+		target = o.size + offset
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if target < 0 {
+		return 0, fmt.Errorf("negative position %d", target)
+	}
+
+	if target != o.offset && o.reader != nil {
+		o.reader.Close()
+		o.reader = nil
+	}
+	o.offset = target
+	return target, nil
+}
+
+func (o *objectReadSeeker) Close() error {
+	if o.reader == nil {
+		return nil
+	}
+	return o.reader.Close()
+}
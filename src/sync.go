@@ -0,0 +1,768 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrSyncRunning = errors.New("a sync is running, try again once it finished")
+
+// ErrTooManyDeletions fails a sync that would delete most of the backups on one side, which
+// usually means the backups directory was lost or the store came back empty
+var ErrTooManyDeletions = errors.New("a sync would delete too many backups")
+
+// SyncPolicy decides how differences between local backups and the backup store are resolved
+type SyncPolicy string
+
+const (
+	// SyncMirrorToCloud makes the store a copy of the local backups, local changes win conflicts
+	SyncMirrorToCloud SyncPolicy = "mirror-to-cloud"
+	// SyncMirrorFromCloud makes the local backups a copy of the store, remote changes win conflicts
+	SyncMirrorFromCloud SyncPolicy = "mirror-from-cloud"
+	// SyncUnion copies new backups both ways and propagates deletions, conflicts are left alone
+	SyncUnion SyncPolicy = "union"
+)
+
+// ParseSyncPolicy validates a policy name, an empty name selects union
+func ParseSyncPolicy(name string) (SyncPolicy, error) {
+	switch SyncPolicy(name) {
+	case "":
+		return SyncUnion, nil
+	case SyncMirrorToCloud, SyncMirrorFromCloud, SyncUnion:
+		return SyncPolicy(name), nil
+	default:
+		return "", fmt.Errorf("unknown sync policy %q, use mirror-to-cloud, mirror-from-cloud or union", name)
+	}
+}
+
+// SyncRecord is the state of a backup after it was last synchronized, both sides were equal then
+type SyncRecord struct {
+	Name       string    `json:"name"`
+	Size       int64     `json:"size"`
+	SHA256     string    `json:"sha256"`
+	ModTime    time.Time `json:"modTime"`    // of the local file
+	Generation string    `json:"generation"` // of the remote object
+	Synced     time.Time `json:"synced"`
+	// LocalOnly marks a backup deleted from the store on purpose, its local copy is not uploaded again.
+	// The marker is dropped when the backup shows up in the store again or the local copy is deleted.
+	LocalOnly bool `json:"localOnly,omitempty"`
+}
+
+type SyncActionType string
+
+const (
+	SyncUpload       SyncActionType = "upload"
+	SyncDownload     SyncActionType = "download"
+	SyncDeleteLocal  SyncActionType = "delete-local"
+	SyncDeleteRemote SyncActionType = "delete-remote"
+	SyncConflict     SyncActionType = "conflict" // nothing is done, the backup needs a manual decision
+	SyncForget       SyncActionType = "forget"   // gone on both sides, only the state record is removed
+)
+
+// SyncAction is a planned step of a sync run
+type SyncAction struct {
+	Name   string         `json:"name"`
+	Action SyncActionType `json:"action"`
+	Reason string         `json:"reason"`
+	Error  string         `json:"error,omitempty"`
+}
+
+// SyncReport lists what a sync run did (or would do with DryRun)
+type SyncReport struct {
+	JobID       string       `json:"jobId,omitempty"`
+	TriggeredBy string       `json:"triggeredBy,omitempty"`
+	Policy      SyncPolicy   `json:"policy"`
+	DryRun      bool         `json:"dryRun"`
+	Started     time.Time    `json:"started"`
+	Finished    time.Time    `json:"finished"`
+	Actions     []SyncAction `json:"actions"`
+	InSync      int          `json:"inSync"`      // backups that needed nothing
+	LocalOnly   int          `json:"localOnly"`   // backups kept out of the store on purpose
+	Transferred int64        `json:"transferred"` // bytes uploaded and downloaded
+	Canceled    bool         `json:"canceled,omitempty"`
+	Error       string       `json:"error,omitempty"`
+}
+
+// Errors returns the number of failed actions
+func (r SyncReport) Errors() int {
+	count := 0
+	for _, action := range r.Actions {
+		if action.Error != "" {
+			count++
+		}
+	}
+	return count
+}
+
+// Conflicts returns the number of backups that need a manual decision
+func (r SyncReport) Conflicts() int {
+	count := 0
+	for _, action := range r.Actions {
+		if action.Action == SyncConflict {
+			count++
+		}
+	}
+	return count
+}
+
+// SyncOptions configure a single sync run
+type SyncOptions struct {
+	DryRun      bool
+	JobID       string
+	TriggeredBy string
+	// Progress is called while actions are applied, it may be nil
+	Progress func(JobProgress)
+}
+
+const maxSyncHistory = 50
+
+// SyncEngine reconciles local backups with a BackupStore. It keeps a record of every synchronized
+// backup so changes and deletions can be told apart from new backups on either side.
+type SyncEngine struct {
+	mu          sync.Mutex // only one sync runs at a time
+	path        string
+	historyPath string
+	store       BackupStore
+	transfers   *TransferManager
+	Policy      SyncPolicy
+	// MaxDeleteShare is the share of the backups on either side a sync may delete, a sync that
+	// would delete all of them is always refused
+	MaxDeleteShare float64
+}
+
+func NewSyncEngine(path, historyPath string, store BackupStore, transfers *TransferManager) *SyncEngine {
+	return &SyncEngine{
+		path:           path,
+		historyPath:    historyPath,
+		store:          store,
+		transfers:      transfers,
+		Policy:         SyncUnion,
+		MaxDeleteShare: 0.5,
+	}
+}
+
+// syncState holds the records of every store sync ran against, keyed by the store's name, so
+// switching stores does not look like every backup was deleted remotely
+type syncState struct {
+	Stores map[string]map[string]SyncRecord `json:"stores"`
+}
+
+// loadState reads the sync state and returns it with the records of the current store. Records
+// written before they were kept per store belong to the current store.
+func (e *SyncEngine) loadState() (*syncState, map[string]SyncRecord, error) {
+	file := &syncState{}
+	if err := readJSONFile(e.path, file); err != nil {
+		return nil, nil, fmt.Errorf("failed to load sync state from %s: %v", e.path, err)
+	}
+	if file.Stores == nil {
+		legacy := map[string]SyncRecord{}
+		if err := readJSONFile(e.path, &legacy); err != nil {
+			return nil, nil, fmt.Errorf("failed to load sync state from %s: %v", e.path, err)
+		}
+		file.Stores = map[string]map[string]SyncRecord{e.store.Name(): legacy}
+	}
+	state := file.Stores[e.store.Name()]
+	if state == nil {
+		state = map[string]SyncRecord{}
+		file.Stores[e.store.Name()] = state
+	}
+	return file, state, nil
+}
+
+// syncSide is a backup as currently found locally or in the store
+type syncSide struct {
+	local   os.FileInfo
+	remote  *ObjectInfo
+	record  *SyncRecord
+	sha256  string // of the local file, only computed when needed
+	changed struct{ local, remote bool }
+	stale   bool // the record is outdated although the content did not change
+}
+
+// Run synchronizes local backups with the store according to the policy. Cancelling ctx stops
+// the run after aborting the current transfer, finished actions are kept. Runs that are not dry
+// runs are added to the history. It fails with ErrSyncRunning instead of waiting for a running sync.
+func (e *SyncEngine) Run(ctx context.Context, opts SyncOptions) (SyncReport, error) {
+	if !e.mu.TryLock() {
+		return SyncReport{}, ErrSyncRunning
+	}
+	defer e.mu.Unlock()
+
+	report := SyncReport{
+		JobID:       opts.JobID,
+		TriggeredBy: opts.TriggeredBy,
+		Policy:      e.Policy,
+		DryRun:      opts.DryRun,
+		Started:     time.Now(),
+		Actions:     []SyncAction{},
+	}
+	err := e.run(ctx, opts, &report)
+	report.Finished = time.Now()
+	if err == nil && report.Errors() > 0 {
+		err = fmt.Errorf("sync finished with %d errors", report.Errors())
+	}
+	if err != nil {
+		report.Error = err.Error()
+		report.Canceled = ctx.Err() != nil
+	}
+
+	if !opts.DryRun {
+		if herr := e.addHistory(report); herr != nil {
+			log.Printf("Error saving sync history: %v", herr)
+		}
+	}
+	return report, err
+}
+
+type plannedSync struct {
+	action SyncAction
+	side   *syncSide
+}
+
+func (e *SyncEngine) run(ctx context.Context, opts SyncOptions, report *SyncReport) error {
+	if e.store == nil {
+		return fmt.Errorf("cloud storage is not configured")
+	}
+
+	if !opts.DryRun {
+		if err := e.store.Prepare(ctx); err != nil {
+			return err
+		}
+	}
+
+	file, state, err := e.loadState()
+	if err != nil {
+		return err
+	}
+
+	sides, err := e.collect(ctx, state)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(sides))
+	for name := range sides {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var planned []plannedSync
+	progress := JobProgress{}
+	var locals, remotes, localDeletions, remoteDeletions int
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		side := sides[name]
+		if side.local != nil {
+			locals++
+		}
+		if side.remote != nil {
+			remotes++
+		}
+		action, err := e.plan(name, side)
+		if err != nil {
+			report.Actions = append(report.Actions, SyncAction{Name: name, Action: SyncConflict, Reason: "failed to compare", Error: err.Error()})
+			continue
+		}
+		if action == nil && side.record != nil && side.record.LocalOnly {
+			report.LocalOnly++
+			continue
+		}
+		if action == nil {
+			report.InSync++
+			// record backups found equal on both sides and rewritten files with unchanged content
+			if !opts.DryRun && (side.record == nil || side.stale) {
+				if record, err := e.record(ctx, name, side.sha256); err == nil {
+					state[name] = record
+				}
+			}
+			continue
+		}
+		switch action.Action {
+		case SyncDeleteLocal:
+			localDeletions++
+		case SyncDeleteRemote:
+			remoteDeletions++
+		}
+		if opts.DryRun || action.Action == SyncConflict {
+			report.Actions = append(report.Actions, *action)
+			continue
+		}
+
+		planned = append(planned, plannedSync{*action, side})
+		progress.ItemsTotal++
+		progress.BytesTotal += transferSize(*action, side)
+	}
+
+	// a lost backups directory must not empty the store and an empty store must not empty the
+	// backups directory
+	if e.tooManyDeletions(remoteDeletions, remotes) {
+		return fmt.Errorf("%w: refusing to delete %d of %d backups from %s", ErrTooManyDeletions, remoteDeletions, remotes, e.store.Name())
+	}
+	if e.tooManyDeletions(localDeletions, locals) {
+		return fmt.Errorf("%w: refusing to delete %d of %d local backups, %s is missing them", ErrTooManyDeletions, localDeletions, locals, e.store.Name())
+	}
+
+	if opts.DryRun {
+		return nil
+	}
+	if err := writeJSONFile(e.path, file); err != nil {
+		return fmt.Errorf("failed to save sync state: %v", err)
+	}
+
+	// transfers run in parallel, mu guards progress, report and state
+	var mu sync.Mutex
+	active := map[string]bool{}
+	reportProgress := func() {
+		names := make([]string, 0, len(active))
+		for name := range active {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		progress.Item = strings.Join(names, ", ")
+		if opts.Progress != nil {
+			opts.Progress(progress)
+		}
+	}
+
+	actions := make([]SyncAction, len(planned))
+	done := make([]bool, len(planned))
+	var saveErr error
+	e.transfers.Each(ctx, len(planned), func(i int) {
+		action := planned[i].action
+		label := fmt.Sprintf("%s %s", action.Action, action.Name)
+
+		mu.Lock()
+		active[label] = true
+		reportProgress()
+		mu.Unlock()
+
+		var sent int64
+		err := e.apply(ctx, action, state, &mu, func(n int64) {
+			mu.Lock()
+			defer mu.Unlock()
+			sent += n
+			progress.BytesDone += n
+			report.Transferred += n
+			reportProgress()
+		})
+		if err != nil {
+			log.Printf("sync: %s failed: %v", label, err)
+			action.Error = err.Error()
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		// count the whole file even if the transfer ended early, so the total stays reachable
+		progress.BytesDone += transferSize(action, planned[i].side) - sent
+		progress.ItemsDone++
+		delete(active, label)
+		reportProgress()
+
+		actions[i] = action
+		done[i] = true
+		if err := writeJSONFile(e.path, file); err != nil && saveErr == nil {
+			saveErr = fmt.Errorf("failed to save sync state: %v", err)
+		}
+	})
+
+	for i, action := range actions {
+		if done[i] {
+			report.Actions = append(report.Actions, action)
+		}
+	}
+	if saveErr != nil {
+		return saveErr
+	}
+	return ctx.Err()
+}
+
+// tooManyDeletions reports whether deleting n of the total backups on one side exceeds MaxDeleteShare
+func (e *SyncEngine) tooManyDeletions(n, total int) bool {
+	return n > 0 && (n == total || float64(n) > e.MaxDeleteShare*float64(total))
+}
+
+// transferSize is the number of bytes an action copies
+func transferSize(action SyncAction, side *syncSide) int64 {
+	switch {
+	case action.Action == SyncUpload && side.local != nil:
+		return side.local.Size()
+	case action.Action == SyncDownload && side.remote != nil:
+		return side.remote.Size
+	}
+	return 0
+}
+
+// History returns past sync runs, newest first
+func (e *SyncEngine) History() ([]SyncReport, error) {
+	history := []SyncReport{}
+	if err := readJSONFile(e.historyPath, &history); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+func (e *SyncEngine) addHistory(report SyncReport) error {
+	history, err := e.History()
+	if err != nil {
+		return err
+	}
+	history = append([]SyncReport{report}, history...)
+	if len(history) > maxSyncHistory {
+		history = history[:maxSyncHistory]
+	}
+	return writeJSONFile(e.historyPath, history)
+}
+
+// collect finds every backup known locally, remotely or from the state
+func (e *SyncEngine) collect(ctx context.Context, state map[string]SyncRecord) (map[string]*syncSide, error) {
+	sides := map[string]*syncSide{}
+	get := func(name string) *syncSide {
+		if sides[name] == nil {
+			sides[name] = &syncSide{}
+		}
+		return sides[name]
+	}
+
+	localBackups, err := GetAvailableBackups(backupsDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range localBackups {
+		path, err := backupPath(name)
+		if err != nil {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		get(name).local = info
+	}
+
+	remoteBackups, err := e.store.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %v", e.store.Name(), err)
+	}
+	for _, name := range remoteBackups {
+		if _, err := backupPath(name); err != nil {
+			log.Printf("sync: ignoring object %s: %v", name, err)
+			continue
+		}
+		info, err := e.store.Stat(ctx, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat %s in %s: %v", name, e.store.Name(), err)
+		}
+		get(name).remote = &info
+	}
+
+	for name, record := range state {
+		record := record
+		get(name).record = &record
+	}
+	return sides, nil
+}
+
+// plan decides what to do with a backup, nil means it is in sync
+func (e *SyncEngine) plan(name string, side *syncSide) (*SyncAction, error) {
+	action := func(t SyncActionType, reason string) (*SyncAction, error) {
+		return &SyncAction{Name: name, Action: t, Reason: reason}, nil
+	}
+	if side.record != nil && side.record.LocalOnly {
+		switch {
+		case side.local == nil && side.remote == nil:
+			return action(SyncForget, "deleted on both sides")
+		case side.remote == nil:
+			return nil, nil
+		}
+		// uploaded again, the backup is synced like one without a record
+		side.record = nil
+	}
+	record := side.record
+
+	if side.local != nil && record != nil {
+		changed, sum, err := localChanged(name, side.local, record)
+		if err != nil {
+			return nil, err
+		}
+		side.changed.local = changed
+		side.sha256 = sum
+		side.stale = !changed && !side.local.ModTime().Equal(record.ModTime)
+	}
+	if side.remote != nil && record != nil {
+		side.changed.remote = side.remote.Generation != record.Generation
+	}
+
+	switch {
+	case side.local == nil && side.remote == nil:
+		return action(SyncForget, "deleted on both sides")
+
+	case side.local != nil && side.remote != nil:
+		if record == nil {
+			same, err := sameContent(name, side.local, side.remote)
+			if err != nil {
+				return nil, err
+			}
+			if same {
+				return nil, nil
+			}
+			return e.resolve(name, "local and remote copies differ")
+		}
+		switch {
+		case side.changed.local && side.changed.remote:
+			return e.resolve(name, "changed on both sides")
+		case side.changed.local:
+			if e.Policy == SyncMirrorFromCloud {
+				return action(SyncDownload, "changed locally, restoring the remote copy")
+			}
+			return action(SyncUpload, "changed locally")
+		case side.changed.remote:
+			if side.remote.MD5 != "" {
+				// a rewritten object with the same content only needs a new record
+				if sum, err := fileMD5(name); err == nil && sum == side.remote.MD5 {
+					side.stale = true
+					return nil, nil
+				}
+			}
+			if e.Policy == SyncMirrorToCloud {
+				return action(SyncUpload, "changed remotely, restoring the local copy")
+			}
+			return action(SyncDownload, "changed remotely")
+		}
+		return nil, nil
+
+	case side.local != nil:
+		if record == nil {
+			if e.Policy == SyncMirrorFromCloud {
+				return action(SyncDeleteLocal, "not in the store")
+			}
+			return action(SyncUpload, "new local backup")
+		}
+		switch e.Policy {
+		case SyncMirrorToCloud:
+			return action(SyncUpload, "deleted remotely, restoring it")
+		case SyncMirrorFromCloud:
+			return action(SyncDeleteLocal, "deleted remotely")
+		}
+		if side.changed.local {
+			return action(SyncConflict, "deleted remotely but changed locally")
+		}
+		return action(SyncDeleteLocal, "deleted remotely")
+
+	default:
+		if record == nil {
+			if e.Policy == SyncMirrorToCloud {
+				return action(SyncDeleteRemote, "not a local backup")
+			}
+			return action(SyncDownload, "new remote backup")
+		}
+		switch e.Policy {
+		case SyncMirrorToCloud:
+			return action(SyncDeleteRemote, "deleted locally")
+		case SyncMirrorFromCloud:
+			return action(SyncDownload, "deleted locally, restoring it")
+		}
+		if side.changed.remote {
+			return action(SyncConflict, "deleted locally but changed remotely")
+		}
+		return action(SyncDeleteRemote, "deleted locally")
+	}
+}
+
+// resolve handles a backup that differs on both sides according to the policy
+func (e *SyncEngine) resolve(name, reason string) (*SyncAction, error) {
+	switch e.Policy {
+	case SyncMirrorToCloud:
+		return &SyncAction{Name: name, Action: SyncUpload, Reason: reason + ", keeping the local copy"}, nil
+	case SyncMirrorFromCloud:
+		return &SyncAction{Name: name, Action: SyncDownload, Reason: reason + ", keeping the remote copy"}, nil
+	default:
+		return &SyncAction{Name: name, Action: SyncConflict, Reason: reason}, nil
+	}
+}
+
+// apply performs an action and updates the state, which is guarded by mu
+func (e *SyncEngine) apply(ctx context.Context, action SyncAction, state map[string]SyncRecord, mu *sync.Mutex, progress TransferProgress) error {
+	path, err := backupPath(action.Name)
+	if err != nil {
+		return err
+	}
+
+	switch action.Action {
+	case SyncUpload:
+		log.Printf("sync: uploading %s to %s (%s)\n", action.Name, e.store.Name(), action.Reason)
+		if err := e.transfers.Upload(ctx, path, progress); err != nil {
+			return err
+		}
+	case SyncDownload:
+		log.Printf("sync: downloading %s from %s (%s)\n", action.Name, e.store.Name(), action.Reason)
+		if err := e.transfers.Download(ctx, action.Name, path, progress); err != nil {
+			return err
+		}
+		// the manifest of a replaced copy describes another archive
+		if err := os.Remove(manifestPath(action.Name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	case SyncDeleteLocal:
+		log.Printf("sync: deleting local backup %s (%s)\n", action.Name, action.Reason)
+		if err := removeBackup(action.Name); err != nil {
+			return err
+		}
+	case SyncDeleteRemote:
+		log.Printf("sync: deleting %s from %s (%s)\n", action.Name, e.store.Name(), action.Reason)
+		if err := e.store.Delete(ctx, action.Name); err != nil && err != ErrObjectNotExist {
+			return err
+		}
+	}
+
+	var record SyncRecord
+	if action.Action == SyncUpload || action.Action == SyncDownload {
+		if record, err = e.record(ctx, action.Name, ""); err != nil {
+			return err
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if record.Name == "" {
+		delete(state, action.Name)
+	} else {
+		state[action.Name] = record
+	}
+	return nil
+}
+
+// record describes a backup that is equal on both sides, sum is the local SHA-256 if already known
+func (e *SyncEngine) record(ctx context.Context, name, sum string) (SyncRecord, error) {
+	path, err := backupPath(name)
+	if err != nil {
+		return SyncRecord{}, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return SyncRecord{}, err
+	}
+	if sum == "" {
+		if sum, _, err = fileSHA256(path); err != nil {
+			return SyncRecord{}, err
+		}
+	}
+	remote, err := e.store.Stat(ctx, name)
+	if err != nil {
+		return SyncRecord{}, err
+	}
+	return SyncRecord{
+		Name:       name,
+		Size:       info.Size(),
+		SHA256:     sum,
+		ModTime:    info.ModTime(),
+		Generation: remote.Generation,
+		Synced:     time.Now(),
+	}, nil
+}
+
+// Update runs fn, which changes backups outside a sync, while no sync is running and saves the
+// records fn changed. It fails with ErrSyncRunning instead of waiting for a running sync.
+func (e *SyncEngine) Update(fn func(state map[string]SyncRecord) error) error {
+	if !e.mu.TryLock() {
+		return ErrSyncRunning
+	}
+	defer e.mu.Unlock()
+
+	file, state, err := e.loadState()
+	if err != nil {
+		return err
+	}
+	if err := fn(state); err != nil {
+		return err
+	}
+	if err := writeJSONFile(e.path, file); err != nil {
+		return fmt.Errorf("failed to save sync state: %v", err)
+	}
+	return nil
+}
+
+// State returns the records of all backups synchronized with the current store
+func (e *SyncEngine) State() ([]SyncRecord, error) {
+	if e.store == nil {
+		return []SyncRecord{}, nil
+	}
+	_, state, err := e.loadState()
+	if err != nil {
+		return nil, err
+	}
+	records := make([]SyncRecord, 0, len(state))
+	for _, record := range state {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Name < records[j].Name
+	})
+	return records, nil
+}
+
+// localChanged compares a local backup with its record, the checksum is only computed when size
+// or modification time differ. It returns the local SHA-256 if it was computed.
+func localChanged(name string, info os.FileInfo, record *SyncRecord) (bool, string, error) {
+	if info.Size() != record.Size {
+		return true, "", nil
+	}
+	if info.ModTime().Equal(record.ModTime) {
+		return false, record.SHA256, nil
+	}
+	path, err := backupPath(name)
+	if err != nil {
+		return false, "", err
+	}
+	sum, _, err := fileSHA256(path)
+	if err != nil {
+		return false, "", err
+	}
+	return sum != record.SHA256, sum, nil
+}
+
+// sameContent compares a local backup with an object of the store that has no record yet,
+// by MD5 if the store knows it and by size otherwise
+func sameContent(name string, local os.FileInfo, remote *ObjectInfo) (bool, error) {
+	if local.Size() != remote.Size {
+		return false, nil
+	}
+	if remote.MD5 == "" {
+		return true, nil
+	}
+	sum, err := fileMD5(name)
+	if err != nil {
+		return false, err
+	}
+	return sum == remote.MD5, nil
+}
+
+func fileMD5(name string) (string, error) {
+	path, err := backupPath(name)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
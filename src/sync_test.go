@@ -0,0 +1,413 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+// chdirTemp runs the test in an empty directory with a backups directory, backupPath is relative
+func chdirTemp(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	if err := os.Mkdir(backupsDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func newTestSyncEngine(t *testing.T, dir string, store BackupStore) *SyncEngine {
+	t.Helper()
+	transfers := NewTransferManager(store)
+	transfers.Backoff = time.Millisecond
+	return NewSyncEngine(filepath.Join(dir, "sync.json"), filepath.Join(dir, "sync-history.json"), store, transfers)
+}
+
+func writeTestBackup(t *testing.T, name, body string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(backupsDir, name), []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func listStore(t *testing.T, store BackupStore) []string {
+	t.Helper()
+	names, err := store.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func listLocal(t *testing.T) []string {
+	t.Helper()
+	names, err := GetAvailableBackups(backupsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestSyncRefusesMassDeletion(t *testing.T) {
+	backups := []string{
+		"world_20240101_000000.zip",
+		"world_20240102_000000.zip",
+		"world_20240103_000000.zip",
+	}
+	tests := []struct {
+		name      string
+		share     float64
+		remove    int
+		fromStore bool // remove the backups from the store instead of the backups directory
+		refused   bool
+		left      int // backups left on the other side after the sync
+	}{
+		{"one of three", 0.5, 1, false, false, 2},
+		{"two of three", 0.5, 2, false, true, 3},
+		{"two of three with a higher share", 0.7, 2, false, false, 1},
+		{"all of them", 1, 3, false, true, 3},
+		{"one of three from the store", 0.5, 1, true, false, 2},
+		{"two of three from the store", 0.5, 2, true, true, 3},
+		{"emptied store", 1, 3, true, true, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			dir := chdirTemp(t)
+			store := &LocalStore{Dir: filepath.Join(dir, "store")}
+			if err := store.Prepare(ctx); err != nil {
+				t.Fatal(err)
+			}
+			e := newTestSyncEngine(t, dir, store)
+			e.MaxDeleteShare = tt.share
+
+			for _, name := range backups {
+				writeTestBackup(t, name, name)
+			}
+			if _, err := e.Run(ctx, SyncOptions{}); err != nil {
+				t.Fatalf("first sync failed: %v", err)
+			}
+			for _, name := range backups[:tt.remove] {
+				path := filepath.Join(backupsDir, name)
+				if tt.fromStore {
+					path = filepath.Join(store.Dir, name)
+				}
+				if err := os.Remove(path); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			preview, err := e.Run(ctx, SyncOptions{DryRun: true})
+			if tt.refused != errors.Is(err, ErrTooManyDeletions) {
+				t.Fatalf("preview error = %v, refused %v", err, tt.refused)
+			}
+			if len(preview.Actions) != tt.remove {
+				t.Errorf("preview planned %d actions, want %d", len(preview.Actions), tt.remove)
+			}
+
+			report, err := e.Run(ctx, SyncOptions{})
+			if tt.refused != errors.Is(err, ErrTooManyDeletions) {
+				t.Fatalf("sync error = %v, refused %v", err, tt.refused)
+			}
+			left := listStore(t, store)
+			if tt.fromStore {
+				left = listLocal(t)
+			}
+			if len(left) != tt.left {
+				t.Errorf("%v left, want %d backups", left, tt.left)
+			}
+
+			history, err := e.History()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(history) != 2 {
+				t.Fatalf("history has %d runs, want 2", len(history))
+			}
+			if last := history[0]; (last.Error != "") != tt.refused || last.Error != report.Error {
+				t.Errorf("recorded error %q, sync error %q, refused %v", last.Error, report.Error, tt.refused)
+			}
+		})
+	}
+}
+
+// TestSyncMirrorRefusesMassDeletion keeps mirror-to-cloud from emptying a store it has no records for
+func TestSyncMirrorRefusesMassDeletion(t *testing.T) {
+	ctx := context.Background()
+	dir := chdirTemp(t)
+	store := &LocalStore{Dir: filepath.Join(dir, "store")}
+	if err := store.Prepare(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"world_20240101_000000.zip", "world_20240102_000000.zip"} {
+		if err := os.WriteFile(filepath.Join(store.Dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTestBackup(t, "world_20240103_000000.zip", "local")
+
+	e := newTestSyncEngine(t, dir, store)
+	e.Policy = SyncMirrorToCloud
+	if _, err := e.Run(ctx, SyncOptions{}); !errors.Is(err, ErrTooManyDeletions) {
+		t.Fatalf("sync error = %v, want ErrTooManyDeletions", err)
+	}
+	if got := listStore(t, store); len(got) != 2 {
+		t.Errorf("store has %v after a refused sync", got)
+	}
+}
+
+// TestSyncStatePerStore switches between two stores, neither looks like its backups were deleted
+func TestSyncStatePerStore(t *testing.T) {
+	ctx := context.Background()
+	dir := chdirTemp(t)
+	first := &LocalStore{Dir: filepath.Join(dir, "first")}
+	second := &LocalStore{Dir: filepath.Join(dir, "second")}
+	for _, store := range []*LocalStore{first, second} {
+		if err := store.Prepare(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTestBackup(t, "world_20240101_000000.zip", "first")
+	writeTestBackup(t, "world_20240102_000000.zip", "second")
+
+	for i, store := range []*LocalStore{first, second, first} {
+		e := newTestSyncEngine(t, dir, store)
+		report, err := e.Run(ctx, SyncOptions{})
+		if err != nil {
+			t.Fatalf("sync %d with %s failed: %v", i, store.Name(), err)
+		}
+		for _, action := range report.Actions {
+			if action.Action != SyncUpload {
+				t.Errorf("sync %d with %s: %s %s (%s)", i, store.Name(), action.Action, action.Name, action.Reason)
+			}
+		}
+		if got := listStore(t, store); len(got) != 2 {
+			t.Errorf("sync %d: %s has %v", i, store.Name(), got)
+		}
+		if got := listLocal(t); len(got) != 2 {
+			t.Errorf("sync %d: local backups are %v", i, got)
+		}
+	}
+}
+
+// TestSyncLegacyState reads records written before they were kept per store
+func TestSyncLegacyState(t *testing.T) {
+	ctx := context.Background()
+	dir := chdirTemp(t)
+	store := &LocalStore{Dir: filepath.Join(dir, "store")}
+	if err := store.Prepare(ctx); err != nil {
+		t.Fatal(err)
+	}
+	e := newTestSyncEngine(t, dir, store)
+	writeTestBackup(t, "world_20240101_000000.zip", "first")
+	if _, err := e.Run(ctx, SyncOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var file syncState
+	if err := readJSONFile(e.path, &file); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeJSONFile(e.path, file.Stores[store.Name()]); err != nil {
+		t.Fatal(err)
+	}
+	report, err := e.Run(ctx, SyncOptions{})
+	if err != nil || len(report.Actions) != 0 || report.InSync != 1 {
+		t.Errorf("sync with legacy state = %+v, %v", report, err)
+	}
+}
+
+// TestSyncKeepsCloudDeletions keeps a backup deleted from the store only locally until it is uploaded again
+func TestSyncKeepsCloudDeletions(t *testing.T) {
+	ctx := context.Background()
+	dir := chdirTemp(t)
+	store := &LocalStore{Dir: filepath.Join(dir, "store")}
+	if err := store.Prepare(ctx); err != nil {
+		t.Fatal(err)
+	}
+	e := newTestSyncEngine(t, dir, store)
+	s := &APIServer{store: store, Syncer: e}
+
+	const (
+		first  = "world_20240101_000000.zip"
+		second = "world_20240102_000000.zip"
+	)
+	writeTestBackup(t, first, "first")
+	writeTestBackup(t, second, "second")
+	if _, err := e.Run(ctx, SyncOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RemoveCloudBackup(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, policy := range []SyncPolicy{SyncUnion, SyncMirrorToCloud, SyncMirrorFromCloud} {
+		e.Policy = policy
+		report, err := e.Run(ctx, SyncOptions{})
+		if err != nil || len(report.Actions) != 0 || report.LocalOnly != 1 {
+			t.Fatalf("%s sync = %+v, %v", policy, report, err)
+		}
+	}
+	if got := listStore(t, store); len(got) != 1 || got[0] != second {
+		t.Errorf("store has %v", got)
+	}
+	if got := listLocal(t); len(got) != 2 {
+		t.Errorf("local backups are %v", got)
+	}
+
+	// uploaded again by hand, the backup is synced again
+	e.Policy = SyncUnion
+	if err := os.WriteFile(filepath.Join(store.Dir, first), []byte("first"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	report, err := e.Run(ctx, SyncOptions{})
+	if err != nil || len(report.Actions) != 0 || report.InSync != 2 || report.LocalOnly != 0 {
+		t.Fatalf("sync after the upload = %+v, %v", report, err)
+	}
+	records, err := e.State()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range records {
+		if record.LocalOnly {
+			t.Errorf("%s is still local only", record.Name)
+		}
+	}
+}
+
+func syncActions(report SyncReport) map[string]SyncActionType {
+	actions := map[string]SyncActionType{}
+	for _, action := range report.Actions {
+		actions[action.Name] = action.Action
+	}
+	return actions
+}
+
+func readTestBackup(t *testing.T, name string) string {
+	t.Helper()
+	body, err := os.ReadFile(filepath.Join(backupsDir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func readTestObject(t *testing.T, b *Bucket, name string) string {
+	t.Helper()
+	r, err := b.Open(context.Background(), name, 0, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	body, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+// TestSyncWithGCS runs the union policy against a fake GCS server
+func TestSyncWithGCS(t *testing.T) {
+	ctx := context.Background()
+	dir := chdirTemp(t)
+	b, server := newTestBucket(t)
+	e := newTestSyncEngine(t, dir, b)
+
+	const (
+		first  = "world_20240101_000000.zip"
+		second = "world_20240102_000000.zip"
+		third  = "world_20240103_000000.zip"
+		fourth = "world_20240104_000000.zip"
+	)
+	writeTestBackup(t, first, "first")
+	writeTestBackup(t, third, "third")
+	writeTestBackup(t, fourth, "fourth")
+	putTestObject(t, server, second, "second")
+
+	run := func(want map[string]SyncActionType) SyncReport {
+		t.Helper()
+		report, err := e.Run(ctx, SyncOptions{})
+		if err != nil {
+			t.Fatalf("sync failed: %v", err)
+		}
+		got := syncActions(report)
+		if len(got) != len(want) {
+			t.Fatalf("sync did %v, want %v", got, want)
+		}
+		for name, action := range want {
+			if got[name] != action {
+				t.Fatalf("sync did %v, want %v", got, want)
+			}
+		}
+		return report
+	}
+
+	run(map[string]SyncActionType{first: SyncUpload, second: SyncDownload, third: SyncUpload, fourth: SyncUpload})
+	if got := readTestBackup(t, second); got != "second" {
+		t.Errorf("downloaded %q", got)
+	}
+	if got := readTestObject(t, b, first); got != "first" {
+		t.Errorf("uploaded %q", got)
+	}
+	if report := run(nil); report.InSync != 4 {
+		t.Errorf("%d backups in sync, want 4", report.InSync)
+	}
+
+	// a backup rewritten by another machine is downloaded again
+	putTestObject(t, server, second, "second, rewritten")
+	run(map[string]SyncActionType{second: SyncDownload})
+	if got := readTestBackup(t, second); got != "second, rewritten" {
+		t.Errorf("downloaded %q after the remote change", got)
+	}
+
+	// deletions are propagated both ways
+	if err := os.Remove(filepath.Join(backupsDir, first)); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Delete(ctx, third); err != nil {
+		t.Fatal(err)
+	}
+	run(map[string]SyncActionType{first: SyncDeleteRemote, third: SyncDeleteLocal})
+	if ok, err := b.Exists(ctx, first); ok || err != nil {
+		t.Errorf("%s is still in the store: %v", first, err)
+	}
+	if ok, _ := exists(filepath.Join(backupsDir, third)); ok {
+		t.Errorf("%s is still a local backup", third)
+	}
+
+	// a backup changed on both sides is left alone
+	writeTestBackup(t, fourth, "fourth, changed locally")
+	putTestObject(t, server, fourth, "fourth, changed remotely")
+	report := run(map[string]SyncActionType{fourth: SyncConflict})
+	if report.Conflicts() != 1 {
+		t.Errorf("%d conflicts, want 1", report.Conflicts())
+	}
+	if got := readTestBackup(t, fourth); got != "fourth, changed locally" {
+		t.Errorf("local copy of the conflict is %q", got)
+	}
+	if got := readTestObject(t, b, fourth); got != "fourth, changed remotely" {
+		t.Errorf("remote copy of the conflict is %q", got)
+	}
+
+	records, err := e.State()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Errorf("state has %d records, want 2", len(records))
+	}
+}
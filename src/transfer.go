@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// TransferManager copies backups between the local disk and a BackupStore. Every transfer is
+// verified against the checksums the store reports and retried with exponential backoff,
+// downloads are written to a temporary file that only replaces the target once it is verified.
+type TransferManager struct {
+	store       BackupStore
+	Concurrency int           // transfers running at the same time
+	Retries     int           // further attempts after a failed one
+	Backoff     time.Duration // delay before the first retry, doubled for every further one
+}
+
+func NewTransferManager(store BackupStore) *TransferManager {
+	return &TransferManager{
+		store:       store,
+		Concurrency: 4,
+		Retries:     4,
+		Backoff:     2 * time.Second,
+	}
+}
+
+// Upload stores the local file under its base name and checks the stored object. The file is read
+// once for its checksums, stores that check a CRC32C themselves get the one computed here.
+func (t *TransferManager) Upload(ctx context.Context, localPath string, progress TransferProgress) error {
+	name := filepath.Base(localPath)
+	sums, err := fileChecksums(localPath)
+	if err != nil {
+		return err
+	}
+	upload := t.store.Upload
+	if store, ok := t.store.(CRC32CUploadStore); ok {
+		upload = func(ctx context.Context, localPath string, progress TransferProgress) error {
+			return store.UploadCRC32C(ctx, localPath, sums.crc32c, progress)
+		}
+	}
+
+	return t.retry(ctx, "upload "+name, progress, func(progress TransferProgress) error {
+		if err := upload(ctx, localPath, progress); err != nil {
+			return err
+		}
+		info, err := t.store.Stat(ctx, name)
+		if err != nil {
+			return err
+		}
+		return sums.verify(info)
+	})
+}
+
+// Download writes the object to localPath once it was downloaded completely and verified
+func (t *TransferManager) Download(ctx context.Context, name, localPath string, progress TransferProgress) error {
+	return t.retry(ctx, "download "+name, progress, func(progress TransferProgress) error {
+		info, err := t.store.Stat(ctx, name)
+		if err != nil {
+			return err
+		}
+
+		tmp := localPath + ".part"
+		defer os.Remove(tmp)
+		if err := t.store.Download(ctx, name, tmp, progress); err != nil {
+			return err
+		}
+
+		sums, err := fileChecksums(tmp)
+		if err != nil {
+			return err
+		}
+		if err := sums.verify(info); err != nil {
+			return err
+		}
+		return os.Rename(tmp, localPath)
+	})
+}
+
+// retry runs fn until it succeeds, fails permanently or ctx is done. Bytes reported by a failed
+// attempt are taken back from progress.
+func (t *TransferManager) retry(ctx context.Context, what string, progress TransferProgress, fn func(progress TransferProgress) error) error {
+	delay := t.Backoff
+	for attempt := 0; ; attempt++ {
+		var sent int64
+		err := fn(func(n int64) {
+			sent += n
+			progress.add(n)
+		})
+		if err == nil {
+			return nil
+		}
+		progress.add(-sent)
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if errors.Is(err, ErrObjectNotExist) || attempt >= t.Retries {
+			return fmt.Errorf("%s failed: %v", what, err)
+		}
+
+		log.Printf("%s failed (attempt %d of %d), retrying in %s: %v", what, attempt+1, t.Retries+1, delay, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// Each calls fn for the indexes 0 to n-1 with at most Concurrency calls running at once,
+// no further calls are started once ctx is done
+func (t *TransferManager) Each(ctx context.Context, n int, fn func(i int)) {
+	limit := make(chan struct{}, max(t.Concurrency, 1))
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for i := 0; i < n; i++ {
+		select {
+		case <-ctx.Done():
+			return
+		case limit <- struct{}{}:
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-limit }()
+			fn(i)
+		}(i)
+	}
+}
+
+// checksums of a local file, compared with what a store reports about the object
+type checksums struct {
+	size   int64
+	md5    string
+	crc32c uint32
+}
+
+func fileChecksums(path string) (checksums, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return checksums{}, err
+	}
+	defer f.Close()
+
+	m := md5.New()
+	c := crc32.New(crc32cTable)
+	size, err := io.Copy(io.MultiWriter(m, c), f)
+	if err != nil {
+		return checksums{}, err
+	}
+	return checksums{
+		size:   size,
+		md5:    hex.EncodeToString(m.Sum(nil)),
+		crc32c: c.Sum32(),
+	}, nil
+}
+
+// verify compares the checksums with an object, checksums the store does not know are skipped
+func (c checksums) verify(info ObjectInfo) error {
+	if info.Size != c.size {
+		return fmt.Errorf("size mismatch, local %d bytes, %s has %d bytes", c.size, info.Name, info.Size)
+	}
+	if info.MD5 != "" && info.MD5 != c.md5 {
+		return fmt.Errorf("MD5 mismatch, local %s, %s has %s", c.md5, info.Name, info.MD5)
+	}
+	if crc := fmt.Sprintf("%08x", c.crc32c); info.CRC32C != "" && info.CRC32C != crc {
+		return fmt.Errorf("CRC32C mismatch, local %s, %s has %s", crc, info.Name, info.CRC32C)
+	}
+	return nil
+}
+
+func fileCRC32C(path string) (uint32, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	c := crc32.New(crc32cTable)
+	if _, err := io.Copy(c, f); err != nil {
+		return 0, err
+	}
+	return c.Sum32(), nil
+}
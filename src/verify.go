@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// VerifyResult is the outcome of checking a single backup archive
+type VerifyResult struct {
+	Name          string    `json:"name"`
+	Remote        bool      `json:"remote"`
+	Verified      time.Time `json:"verified"`
+	OK            bool      `json:"ok"`
+	Entries       int       `json:"entries"`
+	SHA256        string    `json:"sha256,omitempty"`
+	KeyID         string    `json:"keyId,omitempty"`         // encryption key of the backup
+	ChecksumMatch *bool     `json:"checksumMatch,omitempty"` // nil when there is no manifest to compare against
+	HasLevelDat   bool      `json:"hasLevelDat"`
+	Errors        []string  `json:"errors,omitempty"`
+}
+
+// VerifyArchive reads every entry of the backup at archivePath (which validates the CRC-32 of each
+// zip entry or the gzip/zstd checksum of a tar stream), compares the archive checksum with
+// expectedSHA256 if set and checks that the world's level.dat is present
+func VerifyArchive(archivePath, expectedSHA256 string) VerifyResult {
+	result := VerifyResult{
+		Name:     filepath.Base(archivePath),
+		Verified: time.Now(),
+	}
+
+	sum, _, err := fileSHA256(archivePath)
+	if err != nil {
+		result.Errors = append(result.Errors, fmt.Sprintf("failed to read archive: %v", err))
+		return result
+	}
+	result.SHA256 = sum
+	if result.KeyID, err = backupKeyID(archivePath); err != nil {
+		result.Errors = append(result.Errors, fmt.Sprintf("failed to read archive: %v", err))
+		return result
+	}
+	if expectedSHA256 != "" {
+		match := sum == expectedSHA256
+		result.ChecksumMatch = &match
+		if !match {
+			result.Errors = append(result.Errors, fmt.Sprintf("checksum mismatch, manifest has %s", expectedSHA256))
+		}
+	}
+
+	err = walkArchive(archivePath, func(entry archiveEntry, r io.Reader) error {
+		if r == nil {
+			return nil
+		}
+		result.Entries++
+		if path.Base(entry.Name) == "level.dat" {
+			result.HasLevelDat = true
+		}
+		if _, err := io.Copy(io.Discard, r); err != nil {
+			// a broken entry of a zip can be skipped, a broken tar stream cannot be read any further
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", entry.Name, err))
+		}
+		return nil
+	})
+	if err != nil {
+		result.Errors = append(result.Errors, fmt.Sprintf("failed to read archive: %v", err))
+	}
+
+	if !result.HasLevelDat {
+		result.Errors = append(result.Errors, "level.dat not found in archive")
+	}
+
+	result.OK = len(result.Errors) == 0
+	return result
+}
+
+// Verifier checks backups on demand and periodically, results are persisted to a JSON file
+type Verifier struct {
+	mu        sync.Mutex
+	path      string
+	store     BackupStore
+	transfers *TransferManager
+	Interval  time.Duration // how often local backups are verified in the background, 0 disables it
+	results   map[string]VerifyResult
+}
+
+func NewVerifier(path string, store BackupStore, transfers *TransferManager) *Verifier {
+	return &Verifier{
+		path:      path,
+		store:     store,
+		transfers: transfers,
+		Interval:  24 * time.Hour,
+		results:   map[string]VerifyResult{},
+	}
+}
+
+func verifyKey(name string, remote bool) string {
+	if remote {
+		return "remote/" + name
+	}
+	return "local/" + name
+}
+
+// Start loads persisted results and starts the background verifier
+func (v *Verifier) Start() error {
+	var results []VerifyResult
+	if err := readJSONFile(v.path, &results); err != nil {
+		return fmt.Errorf("failed to load verification results from %s: %v", v.path, err)
+	}
+
+	v.mu.Lock()
+	for _, result := range results {
+		v.results[verifyKey(result.Name, result.Remote)] = result
+	}
+	v.mu.Unlock()
+
+	if v.Interval > 0 {
+		go v.loop()
+		log.Printf("background verifier started, interval %s\n", v.Interval)
+	}
+	return nil
+}
+
+func (v *Verifier) loop() {
+	ticker := time.NewTicker(v.Interval)
+	defer ticker.Stop()
+
+	for {
+		v.VerifyAllLocal()
+		<-ticker.C
+	}
+}
+
+// VerifyAllLocal verifies every local backup and drops results of deleted ones
+func (v *Verifier) VerifyAllLocal() {
+	names, err := GetAvailableBackups(backupsDir)
+	if err != nil {
+		log.Printf("Error listing backups for verification: %v", err)
+		return
+	}
+
+	v.mu.Lock()
+	for key, result := range v.results {
+		if !result.Remote && !contains(names, result.Name) {
+			delete(v.results, key)
+		}
+	}
+	v.mu.Unlock()
+
+	corrupt := 0
+	for _, name := range names {
+		if !readableBackup(name) {
+			continue
+		}
+		result, err := v.Verify(context.Background(), name, false)
+		if err != nil {
+			log.Printf("Error verifying %s: %v", name, err)
+			continue
+		}
+		if !result.OK {
+			corrupt++
+			log.Printf("WARNING: backup %s failed verification: %v", name, result.Errors)
+		}
+	}
+	log.Printf("verified %d backups, %d corrupt\n", len(names), corrupt)
+}
+
+// Verify checks a local backup or, with remote set, downloads the backup from the store
+// to a temporary file and checks it
+func (v *Verifier) Verify(ctx context.Context, name string, remote bool) (VerifyResult, error) {
+	if !backupNameRegex.MatchString(name) {
+		return VerifyResult{}, fmt.Errorf("invalid backup name %q", name)
+	}
+	if _, err := archiveFormatOf(name); err != nil {
+		return VerifyResult{}, err
+	}
+
+	// compare against the checksum recorded when the backup was created
+	expected := ""
+	if manifest, err := readManifest(name); err == nil {
+		expected = manifest.SHA256
+	}
+
+	archivePath := filepath.Join(backupsDir, name)
+	if remote {
+		if v.store == nil {
+			return VerifyResult{}, fmt.Errorf("backup storage not configured")
+		}
+		tmpDir, err := os.MkdirTemp("", "verify-")
+		if err != nil {
+			return VerifyResult{}, err
+		}
+		defer os.RemoveAll(tmpDir)
+
+		archivePath = filepath.Join(tmpDir, name)
+		if err := v.transfers.Download(ctx, name, archivePath, nil); err != nil {
+			return VerifyResult{}, err
+		}
+	} else if _, err := os.Stat(archivePath); err != nil {
+		return VerifyResult{}, err
+	}
+
+	result := VerifyArchive(archivePath, expected)
+	result.Remote = remote
+
+	v.mu.Lock()
+	v.results[verifyKey(name, remote)] = result
+	err := v.save()
+	v.mu.Unlock()
+	if err != nil {
+		log.Printf("Error saving verification results: %v", err)
+	}
+
+	return result, nil
+}
+
+// Results returns the latest verification results sorted by name
+func (v *Verifier) Results() []VerifyResult {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	results := make([]VerifyResult, 0, len(v.results))
+	for _, result := range v.results {
+		results = append(results, result)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Name == results[j].Name {
+			return !results[i].Remote
+		}
+		return results[i].Name < results[j].Name
+	})
+	return results
+}
+
+// ResultMap returns the latest results of local or remote backups keyed by backup name
+func (v *Verifier) ResultMap(remote bool) map[string]VerifyResult {
+	results := map[string]VerifyResult{}
+	for _, result := range v.Results() {
+		if result.Remote == remote {
+			results[result.Name] = result
+		}
+	}
+	return results
+}
+
+// save must be called with v.mu held
+func (v *Verifier) save() error {
+	results := make([]VerifyResult, 0, len(v.results))
+	for _, result := range v.results {
+		results = append(results, result)
+	}
+	return writeJSONFile(v.path, results)
+}